@@ -1,20 +1,54 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/planx-lab/planx-common/logger"
 	"github.com/planx-lab/planx-plugin-http/internal/plugin"
 	planxv1 "github.com/planx-lab/planx-proto/gen/go/planx/v1"
 	"github.com/planx-lab/planx-sdk-go/server"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+)
+
+// Set at build time via -ldflags.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = ""
 )
 
 func main() {
 	address := flag.String("address", ":50052", "gRPC server address")
 	debug := flag.Bool("debug", false, "Enable debug logging")
+	metricsAddress := flag.String("metrics-address", "", "Address to serve Prometheus metrics on (disabled if empty)")
+	otelEndpoint := flag.String("otel-endpoint", "", "OTLP/gRPC endpoint to export traces to (disabled if empty)")
+	healthAddress := flag.String("health-address", "", "Address to serve /healthz, /readyz, /sessions and /config-schema on (disabled if empty)")
+	readinessCanary := flag.String("readiness-canary", "", "URL probed by /readyz instead of the active session endpoints")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait for in-flight batches on shutdown")
+	selftestEndpoint := flag.String("selftest-endpoint", "", "URL that must be reachable at startup, or the plugin exits (disabled if empty)")
+	selftestTimeout := flag.Duration("selftest-timeout", 10*time.Second, "How long the startup self-test waits for selftest-endpoint")
+	describeConfig := flag.Bool("describe-config", false, "Print the session config JSON Schema and exit")
 	flag.Parse()
 
+	if *describeConfig {
+		schema, err := plugin.ConfigSchema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
 	// Initialize logger
 	logLevel := "info"
 	if *debug {
@@ -27,6 +61,24 @@ func main() {
 		ServiceName: "planx-plugin-http",
 	})
 
+	if *selftestEndpoint != "" {
+		if err := plugin.SelfTest(context.Background(), *selftestEndpoint, *selftestTimeout); err != nil {
+			logger.Fatal().Err(err).Msg("Startup self-test failed")
+		}
+		logger.Info().Msg("Startup self-test passed")
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := initTracing(context.Background(), *otelEndpoint)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Warn().Err(err).Msg("Failed to flush traces")
+		}
+	}()
+
 	// Create server
 	srv := server.New(server.Config{
 		Address:          *address,
@@ -36,13 +88,112 @@ func main() {
 	})
 
 	// Register sink plugin
+	plugin.SetVersion(Version)
 	sink := plugin.NewHTTPSink()
 	planxv1.RegisterSinkPluginServer(srv.GRPCServer(), sink)
 
-	logger.Info().Str("address", *address).Msg("Starting HTTP sink plugin")
+	if *metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			logger.Info().Str("address", *metricsAddress).Msg("Serving metrics")
+			if err := http.ListenAndServe(*metricsAddress, mux); err != nil {
+				logger.Error().Err(err).Msg("Metrics server error")
+			}
+		}()
+	}
+
+	if *healthAddress != "" {
+		readiness := plugin.NewReadiness(sink, *readinessCanary)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			if err := readiness.Check(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(sink.SessionStats()); err != nil {
+				logger.Warn().Err(err).Msg("Failed to write session stats")
+			}
+		})
+		mux.HandleFunc("/config-schema", func(w http.ResponseWriter, r *http.Request) {
+			schema, err := sink.DescribeConfig(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/schema+json")
+			w.Write(schema)
+		})
+		go func() {
+			logger.Info().Str("address", *healthAddress).Msg("Serving health checks")
+			if err := http.ListenAndServe(*healthAddress, mux); err != nil {
+				logger.Error().Err(err).Msg("Health server error")
+			}
+		}()
+	}
+
+	logger.Info().
+		Str("address", *address).
+		Str("version", Version).
+		Str("commit", Commit).
+		Str("build_time", BuildTime).
+		Msg("Starting HTTP sink plugin")
 
 	// Run server
-	if err := srv.RunWithSignals(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Run() }()
+
+	// On a signal, stop accepting batches and deliver those in flight or
+	// buffered, then stop the server, so no acked data is lost. A second
+	// signal kills the process.
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Server error")
+		}
+		return
+	case <-signalCtx.Done():
+	}
+	stopSignals()
+
+	logger.Info().Dur("timeout", *shutdownTimeout).Msg("Shutting down, flushing sessions")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	res := sink.Shutdown(ctx)
+	ev := logger.Info()
+	if res.Dropped > 0 {
+		ev = logger.Warn()
+	}
+	ev.Int("flushed", res.Completed).Int("dropped", res.Dropped).Msg("Sessions flushed")
+
+	stopServer(ctx, srv.GRPCServer())
+	if err := <-serveErr; err != nil {
 		logger.Fatal().Err(err).Msg("Server error")
 	}
 }
+
+// stopServer stops gs gracefully, letting open streams finish until ctx is
+// done, then closes them.
+func stopServer(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warn().Msg("Shutdown timeout reached, closing open streams")
+		gs.Stop()
+		<-stopped
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"sync"
+
+	planxv1 "github.com/planx-lab/planx-proto/gen/go/planx/v1"
+)
+
+// ackQueue sends acks on a Write stream in request order. AckResponse has
+// no request identifier, so position is the only correlation the client
+// has; acks completed out of order are held until their predecessors are
+// sent. It also serializes Send, which is not safe for concurrent use.
+type ackQueue struct {
+	stream planxv1.SinkPlugin_WriteServer
+
+	mu      sync.Mutex
+	next    int // sequence number of the next ack to send
+	pending map[int]*planxv1.AckResponse
+	sendErr error
+}
+
+func newAckQueue(stream planxv1.SinkPlugin_WriteServer) *ackQueue {
+	return &ackQueue{stream: stream, pending: make(map[int]*planxv1.AckResponse)}
+}
+
+// put records the ack for request seq and sends every ack that is ready.
+func (q *ackQueue) put(seq int, ack *planxv1.AckResponse) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[seq] = ack
+	for {
+		ack, ok := q.pending[q.next]
+		if !ok {
+			return
+		}
+		delete(q.pending, q.next)
+		q.next++
+		if q.sendErr == nil {
+			q.sendErr = q.stream.Send(ack)
+		}
+	}
+}
+
+// err returns the first error returned by Send.
+func (q *ackQueue) err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sendErr
+}
+
+// turn orders the sends of a session's concurrently delivered batches.
+// A nil *turn imposes no ordering.
+type turn struct {
+	prev <-chan struct{} // closed when the preceding batch finished; nil for the first
+	done chan struct{}
+}
+
+// nextTurn returns the turn following the session's most recent one.
+func (st *sessionState) nextTurn() *turn {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	t := &turn{done: make(chan struct{})}
+	if st.lastTurn != nil {
+		t.prev = st.lastTurn.done
+	}
+	st.lastTurn = t
+	return t
+}
+
+// wait blocks until the preceding batch has finished.
+func (t *turn) wait() {
+	if t != nil && t.prev != nil {
+		<-t.prev
+	}
+}
+
+// finish lets the following batch proceed.
+func (t *turn) finish() {
+	if t != nil {
+		close(t.done)
+	}
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrencyConfig replaces a fixed MaxConcurrentRequests with a
+// limit that adapts to the endpoint (AIMD): each healthy batch raises the
+// limit by about one per limit's worth of batches, and a batch failing with
+// 429, a 5xx, a timeout or an open circuit, or slower than LatencyTarget,
+// cuts it by a tenth.
+type AdaptiveConcurrencyConfig struct {
+	Enabled       bool   `json:"enabled"`
+	MinLimit      int    `json:"min_limit"`      // default 1
+	MaxLimit      int    `json:"max_limit"`      // default 32
+	LatencyTarget string `json:"latency_target"` // e.g., "500ms"; unset: errors only
+}
+
+const (
+	defaultAdaptiveMaxLimit = 32
+	adaptiveBackoffRatio    = 0.9
+)
+
+// adaptiveLimit bounds a session's concurrent batches by a limit adjusted
+// from their outcomes.
+type adaptiveLimit struct {
+	min, max int
+	target   time.Duration
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	changed  chan struct{} // closed when a slot may have become available
+}
+
+// newAdaptiveLimit returns nil when adaptive concurrency is disabled.
+func newAdaptiveLimit(cfg Config) (*adaptiveLimit, error) {
+	ac := cfg.AdaptiveConcurrency
+	if !ac.Enabled {
+		if ac != (AdaptiveConcurrencyConfig{}) {
+			return nil, fmt.Errorf("adaptive_concurrency settings require adaptive_concurrency.enabled")
+		}
+		return nil, nil
+	}
+	if cfg.MaxConcurrentRequests > 1 {
+		return nil, fmt.Errorf("max_concurrent_requests cannot be combined with adaptive_concurrency; use adaptive_concurrency.max_limit")
+	}
+	if cfg.MaxAggregateWait != "" {
+		return nil, fmt.Errorf("adaptive_concurrency cannot be combined with aggregation, which sends batches serially")
+	}
+
+	l := &adaptiveLimit{min: ac.MinLimit, max: ac.MaxLimit, changed: make(chan struct{})}
+	if l.min == 0 {
+		l.min = 1
+	}
+	if l.max == 0 {
+		l.max = max(defaultAdaptiveMaxLimit, l.min)
+	}
+	if l.min < 1 || l.max < l.min {
+		return nil, fmt.Errorf("adaptive_concurrency requires 1 <= min_limit <= max_limit, got %d and %d", l.min, l.max)
+	}
+	if ac.LatencyTarget != "" {
+		d, err := time.ParseDuration(ac.LatencyTarget)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid adaptive_concurrency.latency_target %q", ac.LatencyTarget)
+		}
+		l.target = d
+	}
+	l.limit = float64(l.min)
+	return l, nil
+}
+
+// acquire waits until fewer batches than the limit are in flight and
+// reports whether it had to wait.
+func (l *adaptiveLimit) acquire(ctx context.Context) (bool, error) {
+	for waited := false; ; waited = true {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return waited, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+// release ends a batch admitted by acquire.
+func (l *adaptiveLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.notify()
+}
+
+// observe adjusts the limit from the outcome of a batch that took elapsed.
+func (l *adaptiveLimit) observe(state *sessionState, elapsed time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if isOverload(err) || (l.target > 0 && elapsed > l.target) {
+		l.limit = max(l.limit*adaptiveBackoffRatio, float64(l.min))
+	} else if err == nil {
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+	} else {
+		// Other failures, e.g. a rejected payload, say nothing about load.
+		return
+	}
+	concurrencyLimit.WithLabelValues(state.tenantID, state.id).Set(float64(int(l.limit)))
+	l.notify()
+}
+
+// current returns the limit in effect.
+func (l *adaptiveLimit) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// notify wakes waiting acquirers. Callers must hold mu.
+func (l *adaptiveLimit) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// isOverload reports whether err suggests the endpoint is overloaded.
+func isOverload(err error) bool {
+	if err == nil {
+		return false
+	}
+	var perr *partialError
+	if errors.As(err, &perr) {
+		err = perr.first
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= http.StatusInternalServerError
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errCircuitOpen) || isTimeout(err)
+}
+
+// isTimeout reports whether err is a network timeout, e.g. the client timeout.
+func isTimeout(err error) bool {
+	var te interface{ Timeout() bool }
+	return errors.As(err, &te) && te.Timeout()
+}
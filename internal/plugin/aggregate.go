@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	planxv1 "github.com/planx-lab/planx-proto/gen/go/planx/v1"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// aggregator buffers a session's batches so they are sent as one request.
+type aggregator struct {
+	maxRecords int // 0: flush on time only
+	maxWait    time.Duration
+
+	sendMu sync.Mutex // held while an aggregate is sent
+
+	mu      sync.Mutex
+	pending []aggregateEntry
+	records int
+	timer   *time.Timer
+}
+
+// aggregateEntry is a buffered batch and the callback receiving its ack.
+type aggregateEntry struct {
+	ctx  context.Context
+	b    batch.Batch
+	done func(*planxv1.AckResponse)
+}
+
+// newAggregator returns nil when aggregation is not configured.
+func newAggregator(cfg Config) (*aggregator, error) {
+	if cfg.MaxAggregateRecords < 0 {
+		return nil, fmt.Errorf("max_aggregate_records must be >= 0")
+	}
+	if cfg.MaxAggregateWait == "" {
+		if cfg.MaxAggregateRecords > 0 {
+			return nil, fmt.Errorf("max_aggregate_records requires max_aggregate_wait")
+		}
+		return nil, nil
+	}
+	wait, err := time.ParseDuration(cfg.MaxAggregateWait)
+	if err != nil || wait <= 0 {
+		return nil, fmt.Errorf("invalid max_aggregate_wait %q", cfg.MaxAggregateWait)
+	}
+	return &aggregator{maxRecords: cfg.MaxAggregateRecords, maxWait: wait}, nil
+}
+
+// aggregate buffers a packed batch of the session. done receives its ack
+// once the combined request it joined has been sent.
+func (s *HTTPSink) aggregate(ctx context.Context, state *sessionState, packed []byte, done func(*planxv1.AckResponse)) {
+	b, err := batch.UnpackBatch(packed)
+	if err != nil {
+		done(&planxv1.AckResponse{Success: false, Error: fmt.Sprintf("failed to unpack batch: %v", err)})
+		return
+	}
+	if !state.begin() {
+		done(&planxv1.AckResponse{Success: false, Error: fmt.Sprintf("session %s is closing", state.id)})
+		return
+	}
+
+	a := state.agg
+	a.mu.Lock()
+	a.pending = append(a.pending, aggregateEntry{ctx: ctx, b: b, done: done})
+	a.records += len(b.Records)
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.maxWait, func() { s.flushAggregate(state) })
+	}
+	full := a.maxRecords > 0 && a.records >= a.maxRecords
+	a.mu.Unlock()
+
+	if full {
+		s.flushAggregate(state)
+	}
+}
+
+// flushAggregate sends the session's buffered batches as one batch and acks
+// each of them.
+func (s *HTTPSink) flushAggregate(state *sessionState) {
+	a := state.agg
+	a.mu.Lock()
+	entries := a.pending
+	a.pending, a.records = nil, 0
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	var combined batch.Batch
+	for _, e := range entries {
+		combined.Records = append(combined.Records, e.b.Records...)
+	}
+
+	// Aggregates are sent one at a time; a flush triggered by a full buffer
+	// runs on the stream's receive loop, which stops reading meanwhile.
+	waited := !a.sendMu.TryLock()
+	if waited {
+		a.sendMu.Lock()
+	}
+	defer a.sendMu.Unlock()
+
+	// The combined send outlives any single contributing stream.
+	ctx := context.WithoutCancel(entries[0].ctx)
+	var d delivery
+	start := time.Now()
+	err := s.sendBatch(ctx, state, combined, &d)
+	d.throttle(state, waited)
+	state.stats.recordBatch(len(combined.Records), err)
+	s.emitReceipt(ctx, state, len(combined.Records), &d, err, time.Since(start))
+	if err != nil {
+		logSendError(state, err)
+		s.forwardDeadLetter(ctx, state, combined, &d, err)
+	}
+
+	offset := 0
+	for _, e := range entries {
+		n := len(e.b.Records)
+		ack := splitAck(&d, err, offset, n)
+		state.end()
+		e.done(ack)
+		offset += n
+	}
+}
+
+// splitAck returns the ack for the n records at offset of a combined batch.
+func splitAck(d *delivery, err error, offset, n int) *planxv1.AckResponse {
+	var perr *partialError
+	switch {
+	case err == nil:
+	case errors.As(err, &perr):
+		part := &partialError{total: n, first: perr.first}
+		for _, i := range perr.failed {
+			if i >= offset && i < offset+n {
+				part.failed = append(part.failed, i-offset)
+			}
+		}
+		if len(part.failed) > 0 {
+			return &planxv1.AckResponse{Success: false, Error: part.Error()}
+		}
+	default:
+		return &planxv1.AckResponse{Success: false, Error: err.Error()}
+	}
+	return d.slice(offset, n).ack()
+}
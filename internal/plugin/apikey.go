@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiKeyAuth sends a static API key in a header or query parameter.
+type apiKeyAuth struct {
+	name, key string
+	query     bool // sent as a query parameter rather than a header
+}
+
+// newAPIKeyAuth validates the api_key auth settings of cfg.
+func newAPIKeyAuth(cfg Config) (apiKeyAuth, error) {
+	a := apiKeyAuth{name: cfg.Auth.Name, key: cfg.Auth.Key}
+	if a.key == "" || a.name == "" {
+		return apiKeyAuth{}, fmt.Errorf("auth.key and auth.name are required for api_key auth")
+	}
+	switch cfg.Auth.Location {
+	case "", "header":
+		if hasHeader(cfg.Headers, a.name) {
+			return apiKeyAuth{}, fmt.Errorf("api_key auth cannot be combined with a %s header", a.name)
+		}
+	case "query":
+		if _, ok := cfg.QueryParams[a.name]; ok {
+			return apiKeyAuth{}, fmt.Errorf("api_key auth cannot be combined with a %s query parameter", a.name)
+		}
+		a.query = true
+	default:
+		return apiKeyAuth{}, fmt.Errorf("unsupported auth.location %q: must be header or query", cfg.Auth.Location)
+	}
+	return a, nil
+}
+
+func (a apiKeyAuth) apply(_ context.Context, req *http.Request) error {
+	if !a.query {
+		req.Header.Set(a.name, a.key)
+		return nil
+	}
+	mergeQuery(req.URL, url.Values{a.name: {a.key}})
+	return nil
+}
+
+// maskAPIKey returns u with a query API key of auth masked, for logging.
+func maskAPIKey(auth authenticator, u *url.URL) *url.URL {
+	a, ok := auth.(apiKeyAuth)
+	if !ok || !a.query {
+		return u
+	}
+	q := u.Query()
+	if _, ok := q[a.name]; !ok {
+		return u
+	}
+	q.Set(a.name, "REDACTED")
+	masked := *u
+	masked.RawQuery = q.Encode()
+	return &masked
+}
+
+// redactAPIKey masks a query API key of auth in the URL of a transport
+// error, which is logged and returned in acks.
+func redactAPIKey(auth authenticator, err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) || !strings.Contains(ue.URL, "?") {
+		return err
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return err
+	}
+	ue.URL = maskAPIKey(auth, u).String()
+	return err
+}
+
+// apiKeyHeader returns the header carrying the API key of auth, or "".
+func apiKeyHeader(auth authenticator) string {
+	if a, ok := auth.(apiKeyAuth); ok && !a.query {
+		return a.name
+	}
+	return ""
+}
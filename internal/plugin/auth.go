@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig configures how outgoing requests are authenticated.
+type AuthConfig struct {
+	Type  string `json:"type"`  // bearer, basic, oauth2_client_credentials, aws_sigv4, jwt, cookie_login, api_key
+	Token string `json:"token"` // bearer token
+
+	// API key settings. The key is sent in the header or query parameter
+	// Name, per Location: header (default) or query.
+	Key      string `json:"key"`
+	Location string `json:"location"`
+	Name     string `json:"name"` // e.g., X-API-Key or api_key
+
+	// Basic auth credentials. An empty password is sent as-is.
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// OAuth2 client-credentials settings.
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+
+	// AWS Signature V4 settings.
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	Region          string `json:"region"`
+	Service         string `json:"service"` // e.g., execute-api, lambda
+
+	// JWT settings. Tokens are sent as a bearer token unless JWTHeader names
+	// another header to carry the raw token.
+	Algorithm     string `json:"algorithm"`       // RS256, ES256, HS256
+	PrivateKeyPEM string `json:"private_key_pem"` // RS256, ES256
+	JWTSecret     string `json:"jwt_secret"`      // HS256
+	KeyID         string `json:"key_id"`
+	Issuer        string `json:"issuer"`
+	Subject       string `json:"subject"`
+	Audience      string `json:"audience"`
+	TTL           string `json:"ttl"` // token lifetime, e.g., "5m"
+	JWTHeader     string `json:"jwt_header"`
+
+	// Cookie login settings. The session logs in when it is created and
+	// again whenever the endpoint responds 401, sending the cookies the
+	// login response sets on every request they apply to.
+	LoginURL         string `json:"login_url"`
+	LoginMethod      string `json:"login_method"` // POST (default), PUT or GET
+	LoginBody        string `json:"login_body"`
+	LoginContentType string `json:"login_content_type"` // default application/json
+}
+
+// authenticator decorates an outgoing request with credentials.
+type authenticator interface {
+	apply(ctx context.Context, req *http.Request) error
+}
+
+// newAuthenticator validates the auth config and returns the matching
+// authenticator, or nil when no auth is configured. client is used for any
+// requests the authenticator itself needs to make.
+func newAuthenticator(cfg Config, client *http.Client) (authenticator, error) {
+	switch cfg.Auth.Type {
+	case "":
+		return nil, nil
+	case "bearer":
+		if cfg.Auth.Token == "" {
+			return nil, fmt.Errorf("auth.token is required for bearer auth")
+		}
+		if hasHeader(cfg.Headers, "Authorization") {
+			return nil, fmt.Errorf("bearer auth cannot be combined with an Authorization header")
+		}
+		return bearerAuth{token: cfg.Auth.Token}, nil
+	case "basic":
+		if cfg.Auth.Username == "" {
+			return nil, fmt.Errorf("auth.username is required for basic auth")
+		}
+		if hasHeader(cfg.Headers, "Authorization") {
+			return nil, fmt.Errorf("basic auth cannot be combined with an Authorization header")
+		}
+		return basicAuth{username: cfg.Auth.Username, password: cfg.Auth.Password}, nil
+	case "oauth2_client_credentials":
+		if cfg.Auth.TokenURL == "" || cfg.Auth.ClientID == "" || cfg.Auth.ClientSecret == "" {
+			return nil, fmt.Errorf("auth.token_url, auth.client_id and auth.client_secret are required for oauth2_client_credentials auth")
+		}
+		if hasHeader(cfg.Headers, "Authorization") {
+			return nil, fmt.Errorf("oauth2 auth cannot be combined with an Authorization header")
+		}
+		return newOAuth2Auth(cfg.Auth, client), nil
+	case "aws_sigv4":
+		if cfg.Auth.AccessKeyID == "" || cfg.Auth.SecretAccessKey == "" || cfg.Auth.Region == "" || cfg.Auth.Service == "" {
+			return nil, fmt.Errorf("auth.access_key_id, auth.secret_access_key, auth.region and auth.service are required for aws_sigv4 auth")
+		}
+		if hasHeader(cfg.Headers, "Authorization") {
+			return nil, fmt.Errorf("aws_sigv4 auth cannot be combined with an Authorization header")
+		}
+		return sigV4Auth{cfg: cfg.Auth}, nil
+	case "jwt":
+		header := cmp.Or(cfg.Auth.JWTHeader, "Authorization")
+		if hasHeader(cfg.Headers, header) {
+			return nil, fmt.Errorf("jwt auth cannot be combined with a %s header", header)
+		}
+		return newJWTAuth(cfg.Auth)
+	case "cookie_login":
+		return newCookieLoginAuth(cfg, client)
+	case "api_key":
+		return newAPIKeyAuth(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", cfg.Auth.Type)
+	}
+}
+
+// bearerAuth sets a static bearer token.
+type bearerAuth struct {
+	token string
+}
+
+func (a bearerAuth) apply(_ context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// basicAuth sets HTTP basic credentials.
+type basicAuth struct {
+	username, password string
+}
+
+func (a basicAuth) apply(_ context.Context, req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// hasHeader reports whether headers contains name, ignoring case.
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// WeightedEndpoint is one of several equivalent endpoints sharing the load
+// of a session. Weight defaults to 1.
+type WeightedEndpoint struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// loadBalancer picks the endpoint of each request among Config.Endpoints.
+type loadBalancer struct {
+	urls     []string
+	weights  []int
+	random   bool
+	failover bool
+	breakers *breakerRegistry // nil unless the circuit breaker is enabled
+	breaker  breakerSettings
+
+	// Passive health settings; threshold is zero when disabled.
+	threshold        int
+	window, cooldown time.Duration
+
+	mu      sync.Mutex
+	current []int            // smooth weighted round-robin state
+	health  []endpointHealth // nil unless passive health is enabled
+}
+
+// newLoadBalancer validates the endpoint list, returning nil when none is
+// configured. The breakers of endpoint hosts are consulted for their state
+// when the session's breaker settings enable them.
+func newLoadBalancer(cfg Config, breakers *breakerRegistry, breaker breakerSettings) (*loadBalancer, error) {
+	if len(cfg.Endpoints) == 0 {
+		if cfg.LoadBalance != "" || cfg.Failover || cfg.PassiveHealth.FailureThreshold != 0 {
+			return nil, fmt.Errorf("load_balance, failover and passive_health require endpoints")
+		}
+		return nil, nil
+	}
+	if cfg.Endpoint != "" || cfg.EndpointTemplate != "" {
+		return nil, fmt.Errorf("endpoints cannot be combined with endpoint or endpoint_template")
+	}
+
+	lb := &loadBalancer{failover: cfg.Failover, current: make([]int, len(cfg.Endpoints))}
+	if breaker.threshold > 0 {
+		lb.breakers, lb.breaker = breakers, breaker
+	}
+	switch cfg.LoadBalance {
+	case "", "weighted_round_robin":
+	case "random":
+		lb.random = true
+	default:
+		return nil, fmt.Errorf("invalid load_balance %q (must be weighted_round_robin or random)", cfg.LoadBalance)
+	}
+	for i, e := range cfg.Endpoints {
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("endpoints[%d]: invalid url %q", i, e.URL)
+		}
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("endpoints[%d]: weight must be >= 0", i)
+		}
+		w := e.Weight
+		if w == 0 {
+			w = 1
+		}
+		lb.urls = append(lb.urls, e.URL)
+		lb.weights = append(lb.weights, w)
+	}
+	if err := lb.parsePassiveHealth(cfg.PassiveHealth); err != nil {
+		return nil, err
+	}
+	return lb, nil
+}
+
+// pick returns the endpoints to try for one request: the selected one,
+// followed by the others in rotation, in list order, when failover is
+// enabled. An endpoint due for a health probe is selected first. When no
+// endpoint is in rotation all of them are, rather than failing every send.
+func (lb *loadBalancer) pick() []string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := time.Now()
+	up := make([]bool, len(lb.urls))
+	selected, anyUp := -1, false
+	for i := range lb.urls {
+		switch lb.status(i, now) {
+		case endpointUp:
+			up[i], anyUp = true, true
+		case endpointProbe:
+			if selected < 0 {
+				selected = i
+				lb.health[i].probeAt = now
+			}
+		}
+	}
+	if !anyUp && selected < 0 {
+		for i := range up {
+			up[i] = true
+		}
+	}
+	if selected < 0 {
+		if lb.random {
+			selected = lb.nextRandom(up)
+		} else {
+			selected = lb.nextRoundRobin(up)
+		}
+	}
+
+	order := []string{lb.urls[selected]}
+	if lb.failover {
+		for j := 1; j < len(lb.urls); j++ {
+			if i := (selected + j) % len(lb.urls); up[i] {
+				order = append(order, lb.urls[i])
+			}
+		}
+	}
+	return order
+}
+
+// nextRandom picks an endpoint in up at random, in proportion to weight.
+func (lb *loadBalancer) nextRandom(up []bool) int {
+	total := 0
+	for i, w := range lb.weights {
+		if up[i] {
+			total += w
+		}
+	}
+	n := rand.IntN(total)
+	for i, w := range lb.weights {
+		if !up[i] {
+			continue
+		}
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(lb.weights) - 1
+}
+
+// nextRoundRobin implements smooth weighted round-robin over the endpoints
+// in up, which interleaves endpoints rather than sending runs of requests
+// to the heaviest. Callers must hold mu.
+func (lb *loadBalancer) nextRoundRobin(up []bool) int {
+	best, total := -1, 0
+	for i, w := range lb.weights {
+		if !up[i] {
+			continue
+		}
+		lb.current[i] += w
+		total += w
+		if best < 0 || lb.current[i] > lb.current[best] {
+			best = i
+		}
+	}
+	lb.current[best] -= total
+	return best
+}
+
+// shouldFailover reports whether a request that failed with err on one
+// endpoint should be sent to the next. Only failures of the endpoint itself
+// fail over, and requests the endpoint may have processed only when
+// repeating them is safe.
+func (lb *loadBalancer) shouldFailover(ctx context.Context, state *sessionState, method string, err error) bool {
+	if !lb.failover || ctx.Err() != nil {
+		return false
+	}
+	if !isRetriable(ctx, err) && !errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	return isIdempotent(method) || state.cfg.AllowRetryNonIdempotent || !maybeProcessed(err)
+}
+
+// sendBalanced sends o with retries. Sessions with Endpoints send it to the
+// endpoint the load balancer picks, in place of o.url, failing over to the
+// others in turn when enabled.
+func (s *HTTPSink) sendBalanced(ctx context.Context, state *sessionState, d *delivery, o outgoing) error {
+	lb := state.balancer
+	if lb == nil {
+		return s.sendWithRetry(ctx, state, d, o)
+	}
+
+	// The payload is released once every endpoint was tried.
+	defer o.payload.release()
+	o.payload.buf = nil
+
+	urls := lb.pick()
+	var err error
+	for i, u := range urls {
+		o.url = u
+		err = s.sendWithRetry(ctx, state, d, o)
+		if err == nil || i == len(urls)-1 || !lb.shouldFailover(ctx, state, o.method, err) {
+			return err
+		}
+		logger.Warn().
+			Err(err).
+			Str("session_id", state.id).
+			Str("endpoint", redactURL(u)).
+			Str("next_endpoint", redactURL(urls[i+1])).
+			Msg("Endpoint failed; failing over")
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// errBodyTooLarge marks requests refused for exceeding MaxBodyBytes.
+var errBodyTooLarge = errors.New("request body too large")
+
+// checkBodySize refuses p, the encoded body of b, when it exceeds
+// MaxBodyBytes, releasing it. indices maps the records of b onto the
+// batch. The error names the record that pushed the body over the limit:
+// one too large on its own, or else the first at which the records'
+// payloads add up to more than the limit.
+func checkBodySize(state *sessionState, p payload, b batch.Batch, indices []int) error {
+	limit := state.cfg.MaxBodyBytes
+	if limit == 0 || len(p.body) <= limit {
+		return nil
+	}
+	p.release()
+
+	over, total := -1, 0
+	for k, r := range b.Records {
+		if len(r.Payload) > limit {
+			over = k
+			break
+		}
+		if total += len(r.Payload); total > limit && over < 0 {
+			over = k
+		}
+	}
+	if over < 0 {
+		// Only the encoding's overhead exceeds the limit.
+		over = len(b.Records) - 1
+	}
+	if over < 0 {
+		return fmt.Errorf("%w: %d bytes exceed max_body_bytes %d", errBodyTooLarge, len(p.body), limit)
+	}
+	idx := over
+	if indices != nil {
+		idx = indices[over]
+	}
+	return fmt.Errorf("%w: %d bytes exceed max_body_bytes %d at record %d (%d bytes)",
+		errBodyTooLarge, len(p.body), limit, idx, len(b.Records[over].Payload))
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// CircuitBreakerConfig controls the per-endpoint circuit breaker. Sessions
+// sending to the same host with the same settings share its breaker.
+type CircuitBreakerConfig struct {
+	FailureThreshold int    `json:"failure_threshold"` // consecutive 429s, 5xx or transport errors before opening; 0 disables
+	Cooldown         string `json:"cooldown"`          // time spent open before probing, e.g., "30s"
+}
+
+const defaultBreakerCooldown = 30 * time.Second
+
+// errCircuitOpen is returned when a request is shed by an open breaker.
+var errCircuitOpen = errors.New("circuit open")
+
+// breakerSettings is the parsed form of CircuitBreakerConfig.
+type breakerSettings struct {
+	threshold int
+	cooldown  time.Duration
+}
+
+func newBreakerSettings(cfg CircuitBreakerConfig) (breakerSettings, error) {
+	bs := breakerSettings{threshold: cfg.FailureThreshold, cooldown: defaultBreakerCooldown}
+	if bs.threshold < 0 {
+		return breakerSettings{}, fmt.Errorf("circuit_breaker.failure_threshold must be >= 0")
+	}
+	if cfg.Cooldown != "" {
+		d, err := time.ParseDuration(cfg.Cooldown)
+		if err != nil {
+			return breakerSettings{}, fmt.Errorf("invalid circuit_breaker.cooldown: %w", err)
+		}
+		bs.cooldown = d
+	}
+	return bs, nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker tracks consecutive failures for one endpoint host.
+type circuitBreaker struct {
+	host     string
+	settings breakerSettings
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+}
+
+// allow reports whether a request may be sent. In the half-open state only
+// a single probe is let through.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.settings.cooldown {
+			return false
+		}
+		b.transition(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// available reports whether allow would let a request through, without
+// claiming the half-open probe.
+func (b *circuitBreaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return time.Since(b.openedAt) >= b.settings.cooldown
+	case breakerHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+// healthyStatus reports whether a response with status speaks for the
+// health of the endpoint. Rate limiting counts against it, like server
+// errors, so a throttling endpoint is backed off rather than kept busy.
+func healthyStatus(status int) bool {
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
+
+// record reports the outcome of an allowed request.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.transition(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.settings.threshold {
+		b.openedAt = time.Now()
+		if b.state != breakerOpen {
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// current returns the breaker's state.
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// release ends an allowed request without recording an outcome, e.g. when
+// it was cancelled by the caller.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// transition changes state and logs it. Callers must hold mu.
+func (b *circuitBreaker) transition(to breakerState) {
+	from := b.state
+	b.state = to
+
+	ev := logger.Info()
+	if to == breakerOpen {
+		ev = logger.Warn().Int("consecutive_failures", b.failures).Dur("cooldown", b.settings.cooldown)
+	}
+	ev.Str("host", b.host).
+		Str("from", from.String()).
+		Str("to", to.String()).
+		Msg("Circuit breaker state changed")
+}
+
+// breakerKey identifies a breaker. Sessions sending to the same host share
+// its breaker only when their circuit_breaker settings are the same.
+type breakerKey struct {
+	host     string
+	settings breakerSettings
+}
+
+// breakerRegistry holds the breakers of endpoint hosts, shared by sessions.
+// A breaker is dropped once every session that used it has closed, so
+// hosts rendered by endpoint templates do not accumulate.
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[breakerKey]*breakerEntry
+}
+
+type breakerEntry struct {
+	breaker  *circuitBreaker
+	sessions map[string]struct{} // ids of the sessions that used it
+}
+
+func newBreakerRegistry() *breakerRegistry {
+	return &breakerRegistry{breakers: make(map[breakerKey]*breakerEntry)}
+}
+
+// get returns the breaker of host for a session with settings, creating it
+// on first use.
+func (r *breakerRegistry) get(sessionID, host string, settings breakerSettings) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := breakerKey{host, settings}
+	e, ok := r.breakers[key]
+	if !ok {
+		e = &breakerEntry{breaker: &circuitBreaker{host: host, settings: settings}, sessions: make(map[string]struct{})}
+		r.breakers[key] = e
+	}
+	e.sessions[sessionID] = struct{}{}
+	return e.breaker
+}
+
+// lookup returns the breaker of host for settings, or nil when none has
+// been created.
+func (r *breakerRegistry) lookup(host string, settings breakerSettings) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.breakers[breakerKey{host, settings}]; ok {
+		return e.breaker
+	}
+	return nil
+}
+
+// release drops the session's hold on the breakers it used, removing those
+// no other session uses.
+func (r *breakerRegistry) release(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, e := range r.breakers {
+		delete(e.sessions, sessionID)
+		if len(e.sessions) == 0 {
+			delete(r.breakers, key)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHealthyStatus(t *testing.T) {
+	for status, want := range map[int]bool{
+		200: true, 207: true, 400: true, 404: true, 409: true,
+		429: false, 500: false, 502: false, 503: false,
+	} {
+		if got := healthyStatus(status); got != want {
+			t.Errorf("healthyStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestBreakerOpensOnRateLimiting(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		open   bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+	} {
+		t.Run(strconv.Itoa(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			s, state := newTestSession(t, `{
+				"endpoint": "`+srv.URL+`",
+				"circuit_breaker": {"failure_threshold": 2, "cooldown": "1m"}
+			}`)
+			for range 2 {
+				if _, err := send(context.Background(), s, state, testBatch(`{}`)); err == nil {
+					t.Fatal("expected the send to fail")
+				}
+			}
+			_, err := send(context.Background(), s, state, testBatch(`{}`))
+			if open := errors.Is(err, errCircuitOpen); open != tc.open {
+				t.Errorf("after two %d responses: circuit open = %v, want %v (%v)", tc.status, open, tc.open, err)
+			}
+		})
+	}
+}
+
+func TestBreakerRegistryKeysBySettings(t *testing.T) {
+	r := newBreakerRegistry()
+	strict := breakerSettings{threshold: 1, cooldown: time.Minute}
+	lenient := breakerSettings{threshold: 5, cooldown: time.Second}
+
+	a := r.get("a", "api.example", strict)
+	b := r.get("b", "api.example", lenient)
+	if a == b {
+		t.Fatal("sessions with different settings share a breaker")
+	}
+	if b.settings != lenient {
+		t.Errorf("second session's breaker has settings %+v, want %+v", b.settings, lenient)
+	}
+	if c := r.get("c", "api.example", strict); c != a {
+		t.Error("sessions with the same settings do not share a breaker")
+	}
+
+	a.record(false)
+	if a.current() != breakerOpen || b.current() != breakerClosed {
+		t.Errorf("after one failure: strict %s, lenient %s; want open, closed", a.current(), b.current())
+	}
+}
+
+func TestBreakerRegistryRelease(t *testing.T) {
+	r := newBreakerRegistry()
+	settings := breakerSettings{threshold: 1, cooldown: time.Minute}
+	r.get("a", "one.example", settings)
+	r.get("a", "two.example", settings)
+	r.get("b", "two.example", settings)
+
+	r.release("a")
+	if r.lookup("one.example", settings) != nil {
+		t.Error("breaker used only by a closed session was kept")
+	}
+	if r.lookup("two.example", settings) == nil {
+		t.Error("breaker still used by an open session was dropped")
+	}
+	r.release("b")
+	if n := len(r.breakers); n != 0 {
+		t.Errorf("%d breakers left after every session closed", n)
+	}
+}
@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+const (
+	defaultBulkAction = `{"index":{}}`
+
+	// maxBulkResponseBytes bounds the bulk response parsed for item errors.
+	// Bulk responses hold an item per record and grow with the batch.
+	maxBulkResponseBytes = 32 << 20
+)
+
+// itemError reports the records of a request that a bulk or multi-status
+// endpoint rejected individually while accepting the request as a whole.
+type itemError struct {
+	indices []int // record indices in the batch
+	first   error
+}
+
+func (e *itemError) Error() string {
+	return fmt.Sprintf("%d items rejected (indices %v): %v", len(e.indices), e.indices, e.first)
+}
+
+// validateBulk checks the bulk settings, which require the bulk batch format.
+func validateBulk(cfg Config) error {
+	if cfg.BatchFormat != "bulk" && (cfg.BulkAction != "" || cfg.BulkCheckItems) {
+		return fmt.Errorf("bulk_action and bulk_check_items require the bulk batch format")
+	}
+	return nil
+}
+
+// parseBulkAction compiles the bulk action line template, returning nil
+// unless the bulk batch format is used.
+func parseBulkAction(cfg Config) (*template.Template, error) {
+	if cfg.BatchFormat != "bulk" {
+		return nil, nil
+	}
+	text := cfg.BulkAction
+	if text == "" {
+		text = defaultBulkAction
+	}
+	tmpl, err := template.New("bulk_action").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bulk_action: %w", err)
+	}
+	return tmpl, nil
+}
+
+// encodeBulk writes an action line followed by the document line for each
+// record of b, each line terminated by a newline as bulk APIs require.
+func encodeBulk(w io.Writer, state *sessionState, b batch.Batch) error {
+	var action bytes.Buffer
+	for i, r := range b.Records {
+		data := recordWrapperData{
+			TenantId:  state.tenantID,
+			SessionId: state.id,
+			Index:     i,
+			Payload:   string(r.Payload),
+		}
+		dec := json.NewDecoder(bytes.NewReader(r.Payload))
+		dec.UseNumber()
+		_ = dec.Decode(&data.Record)
+
+		action.Reset()
+		if err := state.bulkAction.Execute(&action, data); err != nil {
+			return fmt.Errorf("record %d: bulk_action: %w", i, err)
+		}
+		line := bytes.TrimRight(action.Bytes(), "\r\n")
+		if bytes.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("record %d: bulk_action must render a single line", i)
+		}
+		if bytes.ContainsAny(r.Payload, "\r\n") {
+			// Documents must be single lines; compact any pretty-printed JSON.
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, r.Payload); err != nil {
+				return fmt.Errorf("record %d: payload spans lines and is not valid JSON: %w", i, err)
+			}
+			r.Payload = compact.Bytes()
+		}
+
+		w.Write(line)
+		io.WriteString(w, "\n")
+		w.Write(r.Payload)
+		io.WriteString(w, "\n")
+	}
+	return nil
+}
+
+// checkBulkResponse parses an Elasticsearch-style bulk response and reports
+// the items it rejected, mapping the i-th item onto indices[i]. The parsed
+// body is put back in front of resp.Body so it can still be captured.
+func checkBulkResponse(resp *http.Response, indices []int) error {
+	body, truncated := peekBody(resp, maxBulkResponseBytes)
+	if truncated {
+		return fmt.Errorf("bulk response larger than %d bytes; cannot check items", maxBulkResponseBytes)
+	}
+
+	var br struct {
+		Errors bool                         `json:"errors"`
+		Items  []map[string]json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(body, &br); err != nil {
+		return fmt.Errorf("bulk response is not valid JSON: %w", err)
+	}
+	if !br.Errors {
+		return nil
+	}
+	if indices != nil && len(br.Items) != len(indices) {
+		return fmt.Errorf("bulk response has %d items for %d records", len(br.Items), len(indices))
+	}
+
+	ie := &itemError{}
+	for k, item := range br.Items {
+		// Each item holds a single entry keyed by its action.
+		for action, raw := range item {
+			var result struct {
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			}
+			if err := json.Unmarshal(raw, &result); err != nil {
+				return fmt.Errorf("bulk response item %d is malformed: %w", k, err)
+			}
+			if result.Status < 300 && len(result.Error) == 0 {
+				continue
+			}
+			idx := k
+			if indices != nil {
+				idx = indices[k]
+			}
+			if ie.first == nil {
+				ie.first = fmt.Errorf("%s status %d: %s", action, result.Status, strings.TrimSpace(string(result.Error)))
+			}
+			ie.indices = append(ie.indices, idx)
+		}
+	}
+	if len(ie.indices) == 0 {
+		return nil
+	}
+	return ie
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BulkheadConfig caps the requests a tenant has in flight across all of its
+// sessions, so one tenant cannot exhaust the shared connection pools.
+type BulkheadConfig struct {
+	MaxInFlight int    `json:"max_in_flight"` // per tenant; 0 disables
+	Policy      string `json:"policy"`        // queue (default) or fail_fast
+	MaxQueued   int    `json:"max_queued"`    // requests waiting for a slot under the queue policy; 0 is unbounded
+}
+
+// errBulkheadFull is returned when a tenant's bulkhead rejects a request.
+var errBulkheadFull = errors.New("tenant bulkhead full")
+
+func validateBulkhead(cfg BulkheadConfig) error {
+	if cfg.MaxInFlight < 0 || cfg.MaxQueued < 0 {
+		return fmt.Errorf("bulkhead limits must be >= 0")
+	}
+	switch cfg.Policy {
+	case "", "queue", "fail_fast":
+	default:
+		return fmt.Errorf("unsupported bulkhead.policy %q: must be queue or fail_fast", cfg.Policy)
+	}
+	return nil
+}
+
+// bulkhead limits the in-flight requests of one tenant.
+type bulkhead struct {
+	tenantID string
+	cfg      BulkheadConfig
+	slots    chan struct{}
+
+	mu     sync.Mutex
+	queued int
+}
+
+// acquire takes an in-flight slot, waiting for one under the queue policy.
+func (b *bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		tenantInFlight.WithLabelValues(b.tenantID).Inc()
+		return nil
+	default:
+	}
+
+	if b.cfg.Policy == "fail_fast" {
+		return fmt.Errorf("%w: tenant %s has %d requests in flight", errBulkheadFull, b.tenantID, b.cfg.MaxInFlight)
+	}
+
+	b.mu.Lock()
+	if b.cfg.MaxQueued > 0 && b.queued >= b.cfg.MaxQueued {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: tenant %s has %d requests queued", errBulkheadFull, b.tenantID, b.queued)
+	}
+	b.queued++
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.queued--
+		b.mu.Unlock()
+	}()
+
+	select {
+	case b.slots <- struct{}{}:
+		tenantInFlight.WithLabelValues(b.tenantID).Inc()
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("bulkhead wait: %w", ctx.Err())
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+	tenantInFlight.WithLabelValues(b.tenantID).Dec()
+}
+
+// bulkheadRegistry holds one bulkhead per tenant, shared by all sessions.
+// A bulkhead keeps the settings of the session that created it.
+type bulkheadRegistry struct {
+	mu        sync.Mutex
+	bulkheads map[string]*bulkhead
+}
+
+func newBulkheadRegistry() *bulkheadRegistry {
+	return &bulkheadRegistry{bulkheads: make(map[string]*bulkhead)}
+}
+
+func (r *bulkheadRegistry) get(tenantID string, cfg BulkheadConfig) *bulkhead {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.bulkheads[tenantID]
+	if !ok {
+		b = &bulkhead{tenantID: tenantID, cfg: cfg, slots: make(chan struct{}, cfg.MaxInFlight)}
+		r.bulkheads[tenantID] = b
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultCallbackTimeout = 10 * time.Second
+
+// callbackTarget is a side endpoint that documents about deliveries are
+// posted to as JSON, such as dead letters and receipts.
+type callbackTarget struct {
+	endpoint string
+	header   http.Header
+	timeout  time.Duration
+}
+
+// newCallbackTarget validates the settings of the named config block,
+// returning nil when no endpoint is set.
+func newCallbackTarget(name, endpoint string, headers map[string]string, timeout string) (*callbackTarget, error) {
+	if endpoint == "" {
+		if len(headers) > 0 || timeout != "" {
+			return nil, fmt.Errorf("%s.endpoint is required when %s is configured", name, name)
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s.endpoint %q: must be an absolute http(s) URL", name, redactURL(endpoint))
+	}
+
+	t := &callbackTarget{endpoint: endpoint, header: make(http.Header), timeout: defaultCallbackTimeout}
+	for k, v := range headers {
+		t.header.Set(k, v)
+	}
+	if timeout != "" {
+		if t.timeout, err = time.ParseDuration(timeout); err != nil || t.timeout <= 0 {
+			return nil, fmt.Errorf("invalid %s.timeout %q", name, timeout)
+		}
+	}
+	return t, nil
+}
+
+// post sends v to the target as JSON using the session's client.
+func (t *callbackTarget) post(ctx context.Context, state *sessionState, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal body: %w", err)
+	}
+
+	// The batch's own deadline may already have passed.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = t.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	setUserAgent(state, req.Header)
+
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := readBody(resp.Body, state.cfg.MaxResponseBytes)
+		resp.Body.Close()
+		return &statusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	drainBody(resp.Body)
+	return nil
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority issuing server certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a server certificate for hosts, DNS names or IPs.
+func (ca *testCA) issue(t *testing.T, hosts ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTestTLSServer serves 200 OK over TLS with cert.
+func newTestTLSServer(t *testing.T, cert tls.Certificate) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newCAFileClient returns a session client verifying servers against the
+// CA bundle at path.
+func newCAFileClient(t *testing.T, path string) *http.Client {
+	t.Helper()
+	transport, err := newTransport(Config{TLS: TLSConfig{CACertFile: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(transport.(*connTracker).CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCAReloaderPicksUpChangedFile(t *testing.T) {
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	srv := newTestTLSServer(t, newCA.issue(t, "127.0.0.1"))
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, path, oldCA.pem)
+	r, err := newCAReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := reloaderClient(t, r)
+
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("server signed by a CA missing from the bundle was accepted")
+	}
+
+	// Rotate the CA; the change is only picked up once the reload
+	// interval has passed.
+	writeFile(t, path, append(append([]byte{}, oldCA.pem...), newCA.pem...))
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("bundle reloaded before the reload interval passed")
+	}
+
+	r.mu.Lock()
+	r.checkedAt = time.Now().Add(-caReloadInterval)
+	r.mu.Unlock()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("server signed by the rotated CA was rejected: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestCAReloaderKeepsLastGoodBundle(t *testing.T) {
+	ca := newTestCA(t)
+	srv := newTestTLSServer(t, ca.issue(t, "127.0.0.1"))
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, path, ca.pem)
+	r, err := newCAReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := reloaderClient(t, r)
+
+	writeFile(t, path, []byte("not a certificate"))
+	r.mu.Lock()
+	r.checkedAt = time.Now().Add(-caReloadInterval)
+	r.mu.Unlock()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("invalid bundle replaced the last good one: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestCAReloaderVerifiesHost(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, path, ca.pem)
+
+	tests := []struct {
+		name  string
+		hosts []string
+		ok    bool
+	}{
+		{"matching IP", []string{"127.0.0.1"}, true},
+		{"other IP", []string{"10.1.2.3"}, false},
+		{"other host", []string{"other.example"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestTLSServer(t, ca.issue(t, tt.hosts...))
+			client := newCAFileClient(t, path)
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("Get(%s) with a certificate for %v: err = %v, want success %v", srv.URL, tt.hosts, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCAReloaderVerifyConnectionFailsClosedWithoutHost(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, path, ca.pem)
+	r, err := newCAReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg := &tls.Config{}
+	r.apply(tlsCfg)
+
+	leaf, err := x509.ParseCertificate(ca.issue(t, "10.1.2.3").Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		serverName string
+		ok         bool
+	}{
+		{"", false}, // IP literals send no SNI
+		{"other.example", false},
+		{"10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		err := tlsCfg.VerifyConnection(tls.ConnectionState{ServerName: tt.serverName, PeerCertificates: []*x509.Certificate{leaf}})
+		if (err == nil) != tt.ok {
+			t.Errorf("VerifyConnection with ServerName %q: err = %v, want success %v", tt.serverName, err, tt.ok)
+		}
+	}
+}
+
+// reloaderClient returns a client verifying servers with r, as a
+// ca_cert_file transport does.
+func reloaderClient(t *testing.T, r *caReloader) *http.Client {
+	t.Helper()
+	ht := &http.Transport{TLSClientConfig: &tls.Config{}}
+	r.apply(ht.TLSClientConfig)
+	ht.DialTLSContext = r.dialTLS(ht, (&net.Dialer{}).DialContext)
+	t.Cleanup(ht.CloseIdleConnections)
+	return &http.Client{Transport: ht, Timeout: 5 * time.Second}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// clientCache shares HTTP clients between sessions with identical transport
+// settings. Clients are reference counted and their idle connections closed
+// once the last session using them is released.
+type clientCache struct {
+	mu      sync.Mutex
+	entries map[string]*clientEntry
+}
+
+type clientEntry struct {
+	client *http.Client
+	refs   int
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{entries: make(map[string]*clientEntry)}
+}
+
+// acquire returns the client for key, building it on first use.
+func (c *clientCache) acquire(key string, build func() (*http.Client, error)) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.refs++
+		return e.client, nil
+	}
+
+	client, err := build()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = &clientEntry{client: client, refs: 1}
+	return client, nil
+}
+
+// release drops a reference to the client for key.
+func (c *clientCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e.refs--
+	if e.refs <= 0 {
+		delete(c.entries, key)
+		e.client.CloseIdleConnections()
+		if closer, ok := e.client.Transport.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
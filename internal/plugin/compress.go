@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressor compresses request bodies per Config.Compression.
+type compressor struct {
+	encoding string // Content-Encoding of compressed bodies
+	level    int
+	zstd     *zstd.Encoder // shared for EncodeAll; nil unless zstd
+}
+
+// newCompressor validates the compression settings, returning nil when
+// bodies are sent uncompressed.
+func newCompressor(cfg Config) (*compressor, error) {
+	switch cfg.Compression {
+	case "":
+		if cfg.CompressionLevel != 0 {
+			return nil, fmt.Errorf("compression_level requires compression")
+		}
+		return nil, nil
+	case "gzip":
+		level := gzip.DefaultCompression
+		if cfg.CompressionLevel != 0 {
+			if cfg.CompressionLevel < gzip.BestSpeed || cfg.CompressionLevel > gzip.BestCompression {
+				return nil, fmt.Errorf("compression_level %d out of range for gzip (1-9)", cfg.CompressionLevel)
+			}
+			level = cfg.CompressionLevel
+		}
+		return &compressor{encoding: "gzip", level: level}, nil
+	case "zstd":
+		level := zstd.SpeedDefault
+		if cfg.CompressionLevel != 0 {
+			if cfg.CompressionLevel < 1 || cfg.CompressionLevel > 22 {
+				return nil, fmt.Errorf("compression_level %d out of range for zstd (1-22)", cfg.CompressionLevel)
+			}
+			level = zstd.EncoderLevelFromZstd(cfg.CompressionLevel)
+		}
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return &compressor{encoding: "zstd", level: int(level), zstd: enc}, nil
+	case "br":
+		level := brotli.DefaultCompression
+		if cfg.CompressionLevel != 0 {
+			if cfg.CompressionLevel < 1 || cfg.CompressionLevel > brotli.BestCompression {
+				return nil, fmt.Errorf("compression_level %d out of range for br (1-11)", cfg.CompressionLevel)
+			}
+			level = cfg.CompressionLevel
+		}
+		return &compressor{encoding: "br", level: level}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q: must be gzip, zstd or br", cfg.Compression)
+	}
+}
+
+// compress returns p compressed, leaving p itself untouched. Empty bodies
+// are sent as they are.
+func (c *compressor) compress(p payload) (payload, error) {
+	if p.stream != nil {
+		inner := p.stream
+		p.stream = func(w io.Writer) error {
+			zw, err := c.writer(w)
+			if err != nil {
+				return err
+			}
+			if err := inner(zw); err != nil {
+				zw.Close()
+				return err
+			}
+			return zw.Close()
+		}
+		p.encoding = c.encoding
+		return p, nil
+	}
+	if len(p.body) == 0 {
+		return p, nil
+	}
+
+	buf := getBuffer()
+	if c.zstd != nil {
+		buf.Write(c.zstd.EncodeAll(p.body, buf.AvailableBuffer()))
+	} else {
+		zw, _ := c.writer(buf) // level validated
+		_, err := zw.Write(p.body)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			putBuffer(buf)
+			return payload{}, fmt.Errorf("failed to compress body: %w", err)
+		}
+	}
+	out := pooledPayload(buf, p.contentType)
+	out.encoding = c.encoding
+	return out, nil
+}
+
+// writer returns a compressing writer to w for streamed bodies.
+func (c *compressor) writer(w io.Writer) (io.WriteCloser, error) {
+	if c.zstd != nil {
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevel(c.level)), zstd.WithEncoderConcurrency(1))
+	}
+	if c.encoding == "br" {
+		return brotli.NewWriterLevel(w, c.level), nil
+	}
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+// acceptEncoding is advertised on requests unless the headers set
+// Accept-Encoding, replacing the transport's implicit gzip so that every
+// encoding decodeResponse handles is decoded the same way.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeResponse replaces a gzip, deflate, br or zstd encoded resp.Body
+// with its decoded content, so error bodies, captured responses and
+// extraction see plain text. Bodies in other encodings are left as they
+// are along with their Content-Encoding. The returned func drains and
+// closes the body, whatever is left unread.
+func decodeResponse(resp *http.Response) (release func()) {
+	raw := resp.Body
+	release = func() { drainBody(raw) }
+	var decode func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		decode = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		decode = newDeflateReader
+	case "br":
+		decode = func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }
+	case "zstd":
+		decode = func(r io.Reader) (io.Reader, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		}
+	default:
+		return release
+	}
+	decoded := &decodedBody{body: raw, decode: decode}
+	resp.Body = decoded
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return func() {
+		decoded.Close()
+		drainBody(raw)
+	}
+}
+
+// newDeflateReader reads an HTTP deflate body, which is meant to be zlib
+// wrapped but is sent as raw deflate by some servers.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodedBody decodes body on first read, so a malformed encoding surfaces
+// as a read error rather than when the response arrives. Close releases the
+// decoder only; body is drained and closed by decodeResponse's release.
+type decodedBody struct {
+	body   io.ReadCloser
+	decode func(io.Reader) (io.Reader, error)
+	r      io.Reader
+	err    error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = b.decode(b.body)
+		if b.err != nil {
+			b.err = fmt.Errorf("failed to decode response body: %w", b.err)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// sampleBatchBody returns a JSON array of n records shaped like typical
+// event data.
+func sampleBatchBody(n int) []byte {
+	records := make([]map[string]any, n)
+	for i := range records {
+		records[i] = map[string]any{
+			"id":        i,
+			"timestamp": fmt.Sprintf("2024-05-01T12:%02d:%02d.000Z", i/60%60, i%60),
+			"level":     []string{"info", "warn", "error"}[i%3],
+			"service":   "checkout",
+			"message":   fmt.Sprintf("processed order %d for customer %d", 100000+i, i%97),
+			"latency":   float64(i%250) * 1.5,
+			"tags":      []string{"eu-west-1", "canary"},
+		}
+	}
+	body, _ := json.Marshal(records)
+	return body
+}
+
+func decompress(t testing.TB, encoding string, body []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to decompress %s body: %v", encoding, err)
+	}
+	return out
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := sampleBatchBody(200)
+	for _, tc := range []struct {
+		compression string
+		level       int
+	}{
+		{"gzip", 0}, {"gzip", 9},
+		{"zstd", 0}, {"zstd", 19},
+		{"br", 0}, {"br", 11},
+	} {
+		t.Run(fmt.Sprintf("%s/%d", tc.compression, tc.level), func(t *testing.T) {
+			c, err := newCompressor(Config{Compression: tc.compression, CompressionLevel: tc.level})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := c.compress(payload{body: body, contentType: "application/json"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.release()
+			if p.encoding != tc.compression {
+				t.Errorf("encoding = %q, want %q", p.encoding, tc.compression)
+			}
+			if len(p.body) >= len(body) {
+				t.Errorf("compressed body is %d bytes, not smaller than %d", len(p.body), len(body))
+			}
+			if got := decompress(t, tc.compression, p.body); !bytes.Equal(got, body) {
+				t.Error("decompressed body differs from the original")
+			}
+
+			streamed, err := c.compress(payload{stream: func(w io.Writer) error {
+				_, err := w.Write(body)
+				return err
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := streamed.stream(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := decompress(t, tc.compression, buf.Bytes()); !bytes.Equal(got, body) {
+				t.Error("decompressed streamed body differs from the original")
+			}
+		})
+	}
+}
+
+func TestNewCompressorValidation(t *testing.T) {
+	for _, tc := range []struct {
+		compression string
+		level       int
+		err         string
+	}{
+		{"", 0, ""},
+		{"", 5, "compression_level requires compression"},
+		{"gzip", 10, "out of range for gzip (1-9)"},
+		{"zstd", 23, "out of range for zstd (1-22)"},
+		{"br", 12, "out of range for br (1-11)"},
+		{"br", -1, "out of range for br (1-11)"},
+		{"lz4", 0, "must be gzip, zstd or br"},
+	} {
+		_, err := newCompressor(Config{Compression: tc.compression, CompressionLevel: tc.level})
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("newCompressor(%q, %d): unexpected error %v", tc.compression, tc.level, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("newCompressor(%q, %d): error %v, want it to contain %q", tc.compression, tc.level, err, tc.err)
+		}
+	}
+}
+
+// BenchmarkCompress compares the algorithms on a representative batch,
+// reporting the compression ratio next to the time per batch.
+func BenchmarkCompress(b *testing.B) {
+	body := sampleBatchBody(500)
+	for _, tc := range []struct {
+		compression string
+		level       int
+	}{
+		{"gzip", 1}, {"gzip", 0}, {"gzip", 9},
+		{"zstd", 1}, {"zstd", 0}, {"zstd", 19},
+		{"br", 1}, {"br", 0}, {"br", 11},
+	} {
+		b.Run(fmt.Sprintf("%s/level=%d", tc.compression, tc.level), func(b *testing.B) {
+			c, err := newCompressor(Config{Compression: tc.compression, CompressionLevel: tc.level})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			var size int
+			for b.Loop() {
+				p, err := c.compress(payload{body: body})
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(p.body)
+				p.release()
+			}
+			b.ReportMetric(float64(len(body))/float64(size), "ratio")
+		})
+	}
+}
+
+func TestDecodeResponseErrorBody(t *testing.T) {
+	const errBody = `{"error":"field \"id\" is required"}`
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		encode   func(io.Writer) io.WriteCloser
+	}{
+		{"gzip", "gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"zlib deflate", "deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"raw deflate", "deflate", func(w io.Writer) io.WriteCloser {
+			zw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return zw
+		}},
+		{"br", "br", func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }},
+		{"zstd", "zstd", func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// zstd is decoded but not advertised.
+				if ae := r.Header.Get("Accept-Encoding"); tc.encoding != "zstd" && !strings.Contains(ae, tc.encoding) {
+					t.Errorf("Accept-Encoding %q does not advertise %s", ae, tc.encoding)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", tc.encoding)
+				w.WriteHeader(http.StatusBadRequest)
+				zw := tc.encode(w)
+				io.WriteString(zw, errBody)
+				zw.Close()
+			}))
+			defer srv.Close()
+
+			s, state := newTestSession(t, fmt.Sprintf(`{"endpoint": %q}`, srv.URL))
+			_, err := send(context.Background(), s, state, testBatch(`{"name":"a"}`))
+			if err == nil {
+				t.Fatal("expected an error for HTTP 400")
+			}
+			if want := "HTTP 400: " + errBody; err.Error() != want {
+				t.Errorf("error = %q, want %q", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// saturationWarnInterval limits how often a saturated host is logged.
+const saturationWarnInterval = time.Minute
+
+// reuseSummaryInterval is how often connection reuse is summarized in the
+// log, while requests are sent.
+const reuseSummaryInterval = time.Minute
+
+var errConnWait = errors.New("timed out waiting for a connection")
+
+// connTracker counts a transport's connections per dialed host, publishing
+// how many are in use and idle. When MaxConnsPerHost is reached requests
+// wait for a connection, for at most waitTimeout when it is set.
+type connTracker struct {
+	idleCloser
+	maxPerHost  int
+	waitTimeout time.Duration
+
+	trackReuse bool
+
+	mu     sync.Mutex
+	active map[string]int       // host -> connections in use
+	warned map[string]time.Time // host -> last saturation warning
+
+	// Connections obtained per host since the last reuse summary, new
+	// and reused; nil unless trackReuse.
+	reuse        map[string]*[2]int
+	summarizedAt time.Time
+}
+
+// trackedConn is a connection dialed through a connTracker. inUse counts
+// the requests using it and is guarded by the tracker's mu.
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	host    string
+	inUse   int
+	closed  bool
+}
+
+func newConnTracker(tc TransportConfig) (*connTracker, error) {
+	t := &connTracker{
+		maxPerHost: tc.MaxConnsPerHost,
+		trackReuse: tc.TrackConnReuse,
+		active:     make(map[string]int),
+		warned:     make(map[string]time.Time),
+	}
+	if t.trackReuse {
+		t.reuse = make(map[string]*[2]int)
+		t.summarizedAt = time.Now()
+	}
+	if tc.ConnWaitTimeout != "" {
+		d, err := time.ParseDuration(tc.ConnWaitTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid transport.conn_wait_timeout %q", tc.ConnWaitTimeout)
+		}
+		if tc.MaxConnsPerHost == 0 {
+			return nil, fmt.Errorf("transport.conn_wait_timeout requires transport.max_conns_per_host")
+		}
+		t.waitTimeout = d
+	}
+	return t, nil
+}
+
+// dial wraps next so the connections it opens are counted.
+func (t *connTracker) dial(next dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		poolConnections.WithLabelValues(addr, "idle").Inc()
+		return &trackedConn{Conn: conn, tracker: t, host: addr}, nil
+	}
+}
+
+// RoundTrip marks the connection serving req in use until its response
+// body is closed.
+func (t *connTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+
+	var (
+		conn    *trackedConn
+		claimed atomic.Bool // set once a connection is obtained or the wait timed out
+		timer   *time.Timer
+	)
+	if t.waitTimeout > 0 {
+		timer = time.AfterFunc(t.waitTimeout, func() {
+			if claimed.CompareAndSwap(false, true) {
+				cancel(errConnWait)
+			}
+		})
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			claimed.Store(true)
+			if timer != nil {
+				timer.Stop()
+			}
+			// The transport may retry on another connection after a
+			// reused one turned out to be closed.
+			if conn != nil {
+				t.release(conn)
+				conn = nil
+			}
+			host := req.URL.Host
+			if c := unwrapTrackedConn(info.Conn); c != nil {
+				t.acquire(c)
+				conn = c
+				host = c.host
+			}
+			if t.trackReuse {
+				t.observeReuse(host, info.Reused)
+			}
+		},
+	}
+
+	resp, err := t.idleCloser.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		if conn != nil {
+			t.release(conn)
+		}
+		if context.Cause(ctx) == errConnWait {
+			err = fmt.Errorf("%w to %s within %s (max_conns_per_host %d)", errConnWait, req.URL.Host, t.waitTimeout, t.maxPerHost)
+		}
+		cancel(nil)
+		return nil, err
+	}
+
+	var once sync.Once
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() {
+		once.Do(func() {
+			if conn != nil {
+				t.release(conn)
+			}
+			cancel(nil)
+		})
+	}}
+	return resp, nil
+}
+
+// observeReuse counts a connection obtained for host, logging a summary of
+// reuse per host every reuseSummaryInterval.
+func (t *connTracker) observeReuse(host string, reused bool) {
+	connAcquisitions.WithLabelValues(host, strconv.FormatBool(reused)).Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := t.reuse[host]
+	if counts == nil {
+		counts = new([2]int)
+		t.reuse[host] = counts
+	}
+	if reused {
+		counts[1]++
+	} else {
+		counts[0]++
+	}
+
+	if time.Since(t.summarizedAt) < reuseSummaryInterval {
+		return
+	}
+	for h, c := range t.reuse {
+		logger.Info().
+			Str("host", h).
+			Int("new", c[0]).
+			Int("reused", c[1]).
+			Dur("interval", time.Since(t.summarizedAt)).
+			Msg("Connection reuse")
+	}
+	clear(t.reuse)
+	t.summarizedAt = time.Now()
+}
+
+// acquire marks c in use by one more request.
+func (t *connTracker) acquire(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.inUse++
+	if c.inUse > 1 || c.closed {
+		return // multiplexed HTTP/2 stream
+	}
+	poolConnections.WithLabelValues(c.host, "idle").Dec()
+	poolConnections.WithLabelValues(c.host, "active").Inc()
+	t.active[c.host]++
+
+	if t.maxPerHost > 0 && t.active[c.host] >= t.maxPerHost && time.Since(t.warned[c.host]) >= saturationWarnInterval {
+		t.warned[c.host] = time.Now()
+		logger.Warn().
+			Str("host", c.host).
+			Int("max_conns_per_host", t.maxPerHost).
+			Msg("Host connection pool saturated; requests wait for a free connection")
+	}
+}
+
+// release marks c in use by one fewer request.
+func (t *connTracker) release(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.inUse--
+	if c.inUse > 0 || c.closed {
+		return
+	}
+	poolConnections.WithLabelValues(c.host, "active").Dec()
+	poolConnections.WithLabelValues(c.host, "idle").Inc()
+	t.active[c.host]--
+}
+
+func (c *trackedConn) Close() error {
+	t := c.tracker
+	t.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		if c.inUse > 0 {
+			poolConnections.WithLabelValues(c.host, "active").Dec()
+			t.active[c.host]--
+		} else {
+			poolConnections.WithLabelValues(c.host, "idle").Dec()
+		}
+	}
+	t.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// unwrapTrackedConn returns the trackedConn beneath conn, e.g. a TLS
+// connection, or nil.
+func unwrapTrackedConn(conn net.Conn) *trackedConn {
+	for {
+		switch c := conn.(type) {
+		case *trackedConn:
+			return c
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// releasingBody calls release once the body is closed or fully read.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieLoginAuth authenticates with session cookies obtained from a login
+// request. The cookies live in a jar private to the session, attached to a
+// copy of the shared client, so sessions never see each other's cookies.
+type cookieLoginAuth struct {
+	cfg    AuthConfig
+	client *http.Client // the session client, with the jar
+
+	// mu serializes logins so concurrent sends share one.
+	mu         sync.Mutex
+	loggedInAt time.Time // zero until the first login or after invalidate
+}
+
+func newCookieLoginAuth(cfg Config, client *http.Client) (*cookieLoginAuth, error) {
+	if cfg.Auth.LoginURL == "" {
+		return nil, fmt.Errorf("auth.login_url is required for cookie_login auth")
+	}
+	if u, err := url.Parse(cfg.Auth.LoginURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid auth.login_url %q", cfg.Auth.LoginURL)
+	}
+	switch cfg.Auth.LoginMethod {
+	case "", http.MethodPost, http.MethodPut, http.MethodGet:
+	default:
+		return nil, fmt.Errorf("invalid auth.login_method %q (must be POST, PUT or GET)", cfg.Auth.LoginMethod)
+	}
+	if hasHeader(cfg.Headers, "Cookie") {
+		return nil, fmt.Errorf("cookie_login auth cannot be combined with a Cookie header")
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := *client
+	c.Jar = jar
+	return &cookieLoginAuth{cfg: cfg.Auth, client: &c}, nil
+}
+
+// apply logs in unless the session already has cookies. The client adds
+// them to req from its jar.
+func (a *cookieLoginAuth) apply(ctx context.Context, _ *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loggedInAt.IsZero() {
+		return nil
+	}
+	return a.loginLocked(ctx)
+}
+
+// login performs the login request, replacing the session cookies.
+func (a *cookieLoginAuth) login(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.loginLocked(ctx)
+}
+
+func (a *cookieLoginAuth) loginLocked(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, cmp.Or(a.cfg.LoginMethod, http.MethodPost), a.cfg.LoginURL, strings.NewReader(a.cfg.LoginBody))
+	if err != nil {
+		return fmt.Errorf("auth failed: failed to create login request: %w", err)
+	}
+	if a.cfg.LoginBody != "" {
+		req.Header.Set("Content-Type", cmp.Or(a.cfg.LoginContentType, "application/json"))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("auth failed: login request failed: %w", err)
+	}
+	defer drainBody(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("auth failed: login endpoint returned HTTP %d", resp.StatusCode)
+	}
+	if len(resp.Cookies()) == 0 {
+		return fmt.Errorf("auth failed: login response set no cookies")
+	}
+	a.loggedInAt = time.Now()
+	return nil
+}
+
+// invalidate discards the login after a request sent at sentAt was
+// rejected, unless the session logged in again since.
+func (a *cookieLoginAuth) invalidate(sentAt time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.loggedInAt.Before(sentAt) {
+		a.loggedInAt = time.Time{}
+	}
+}
+
+// reauthenticator is an authenticator whose credentials the endpoint can
+// revoke, so that a 401 response calls for fresh ones.
+type reauthenticator interface {
+	invalidate(sentAt time.Time)
+}
+
+// reauthenticate reports whether err is a 401 response to a request sent
+// at sentAt that should be resent with fresh credentials, invalidating the
+// current ones if so.
+func reauthenticate(state *sessionState, err error, sentAt time.Time) bool {
+	ra, ok := state.auth.(reauthenticator)
+	var se *statusError
+	if !ok || !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	ra.invalidate(sentAt)
+	return true
+}
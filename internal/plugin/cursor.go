@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CursorConfig follows a cursor returned by endpoints that continue an
+// operation asynchronously, such as bulk import APIs that must be polled
+// for their final status. Once a batch is accepted, the cursor at Path in
+// the response is requested with Method, as a URL resolved against the
+// request's, or via the Endpoint template with the cursor, path-escaped,
+// as .Cursor.
+// Follow-ups continue every Interval until a response carries no cursor,
+// whose outcome is the batch's; a cursor still returned after
+// MaxIterations follow-ups fails the batch. Follow-ups carry the configured
+// headers and auth, but not the idempotency key, integrity or event time
+// headers of the request they follow.
+type CursorConfig struct {
+	Path          string `json:"path"`           // e.g. "$.next"; empty disables
+	Method        string `json:"method"`         // default GET
+	Endpoint      string `json:"endpoint"`       // e.g. "https://api.example.com/imports/{{.Cursor}}"
+	Interval      string `json:"interval"`       // default 1s
+	MaxIterations int    `json:"max_iterations"` // default 10
+}
+
+const (
+	defaultCursorInterval      = time.Second
+	defaultCursorMaxIterations = 10
+)
+
+// cursorFollower is the parsed form of CursorConfig.
+type cursorFollower struct {
+	path          []string
+	method        string
+	endpoint      *template.Template // nil to request the cursor itself
+	interval      time.Duration
+	maxIterations int
+}
+
+// newCursorFollower validates cfg, returning nil when it is disabled.
+func newCursorFollower(cfg CursorConfig) (*cursorFollower, error) {
+	if cfg.Path == "" {
+		if cfg != (CursorConfig{}) {
+			return nil, fmt.Errorf("cursor settings require cursor.path")
+		}
+		return nil, nil
+	}
+
+	path, err := parseExtractPath(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor.path: %w", err)
+	}
+	f := &cursorFollower{path: path, method: http.MethodGet, interval: defaultCursorInterval, maxIterations: defaultCursorMaxIterations}
+	switch cfg.Method {
+	case "", http.MethodGet:
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		f.method = cfg.Method
+	default:
+		return nil, fmt.Errorf("unsupported cursor.method %q: must be one of GET, POST, PUT, PATCH, DELETE", cfg.Method)
+	}
+	if cfg.Endpoint != "" {
+		if f.endpoint, err = template.New("cursor").Option("missingkey=error").Parse(cfg.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid cursor.endpoint template: %w", err)
+		}
+	}
+	if cfg.Interval != "" {
+		if f.interval, err = time.ParseDuration(cfg.Interval); err != nil || f.interval < 0 {
+			return nil, fmt.Errorf("invalid cursor.interval %q", cfg.Interval)
+		}
+	}
+	if cfg.MaxIterations < 0 {
+		return nil, fmt.Errorf("cursor.max_iterations must be >= 0")
+	}
+	if cfg.MaxIterations > 0 {
+		f.maxIterations = cfg.MaxIterations
+	}
+	return f, nil
+}
+
+// cursor returns the cursor in resp, or "" when it carries none. The body
+// is put back in front of resp.Body so it can still be captured.
+func (f *cursorFollower) cursor(resp *http.Response) (string, error) {
+	body, truncated := peekBody(resp, maxExtractBytes)
+	if truncated {
+		return "", fmt.Errorf("response larger than %d bytes; cannot read cursor", maxExtractBytes)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("response is not valid JSON; cannot read cursor: %w", err)
+	}
+	v, _ = lookupPath(v, f.path)
+	switch c := v.(type) {
+	case string:
+		return c, nil
+	case json.Number:
+		return c.String(), nil
+	default:
+		return "", nil
+	}
+}
+
+// followURL returns the URL requesting cursor, relative to base.
+func (f *cursorFollower) followURL(base *url.URL, cursor string) (string, error) {
+	if f.endpoint == nil {
+		u, err := base.Parse(cursor)
+		if err != nil {
+			return "", fmt.Errorf("cursor %q is not a URL: %w", cursor, err)
+		}
+		return u.String(), nil
+	}
+	var sb strings.Builder
+	if err := f.endpoint.Execute(&sb, struct{ Cursor string }{url.PathEscape(cursor)}); err != nil {
+		return "", fmt.Errorf("failed to render cursor.endpoint: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// followCursor follows the cursors starting at resp, the accepted response
+// to o, and records the final response in d. Follow-up failures are not
+// retried by sendWithRetry, as the batch itself was accepted.
+func (s *HTTPSink) followCursor(ctx context.Context, state *sessionState, d *delivery, resp *http.Response, o outgoing) error {
+	f := state.cursor
+	for i := 0; ; i++ {
+		cursor, err := f.cursor(resp)
+		if err != nil {
+			return err
+		}
+		if cursor == "" {
+			break
+		}
+		if i == f.maxIterations {
+			return fmt.Errorf("operation still incomplete after %d cursor follow-ups", i)
+		}
+		if err := sleepContext(ctx, f.interval); err != nil {
+			return err
+		}
+
+		next, err := s.requestCursor(ctx, state, o, resp.Request.URL, cursor)
+		if err != nil {
+			return err
+		}
+		defer next.release()
+		resp = next.Response
+	}
+
+	if state.cfg.CaptureResponse || state.extractPath != nil {
+		d.record(state, resp, o.indices)
+	}
+	return nil
+}
+
+// cursorResponse is a successful follow-up response; release drains and
+// closes it.
+type cursorResponse struct {
+	*http.Response
+	release func()
+}
+
+// requestCursor sends the follow-up request for cursor, returning its
+// response once successful. Its failures are reported as not retriable.
+func (s *HTTPSink) requestCursor(ctx context.Context, state *sessionState, o outgoing, base *url.URL, cursor string) (cursorResponse, error) {
+	f := state.cursor
+	target, err := f.followURL(base, cursor)
+	if err != nil {
+		return cursorResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, f.method, target, nil)
+	if err != nil {
+		return cursorResponse{}, fmt.Errorf("failed to create cursor request: %w", err)
+	}
+	for k, v := range cursorHeader(state, o.header) {
+		req.Header[k] = slices.Clone(v)
+	}
+	setUserAgent(state, req.Header)
+	if state.cfg.Accept != "" {
+		req.Header.Set("Accept", state.cfg.Accept)
+	} else if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", defaultAccept)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	// Credentials are not sent to another host named by a cursor URL.
+	if state.auth != nil && (f.endpoint != nil || req.URL.Host == base.Host) {
+		if err := state.auth.apply(ctx, req); err != nil {
+			return cursorResponse{}, err
+		}
+	}
+	injectTraceContext(ctx, req)
+
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return cursorResponse{}, fmt.Errorf("cursor request failed: %v", redactAPIKey(state.auth, err))
+	}
+	release := decodeResponse(resp)
+	if !state.status.isSuccess(resp.StatusCode) {
+		body, _ := readBody(resp.Body, state.cfg.MaxResponseBytes)
+		release()
+		return cursorResponse{}, fmt.Errorf("cursor request: %w", &statusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+	return cursorResponse{resp, release}, nil
+}
+
+// cursorHeader returns the headers of a follow-up to a request with header:
+// the configured ones, without those describing the request's own records
+// and body, which the follow-up does not carry. Auth is applied separately.
+func cursorHeader(state *sessionState, header http.Header) http.Header {
+	h := header.Clone()
+	for _, name := range []string{state.cfg.IdempotencyKeyHeader, state.cfg.RecordCountHeader, state.cfg.ChecksumHeader} {
+		if name != "" {
+			h.Del(name)
+		}
+	}
+	if state.eventTime != nil {
+		h.Del(state.eventTime.minHeader)
+		h.Del(state.eventTime.maxHeader)
+	}
+	return h
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCursorFollowUpHeaders(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		followUp http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/status" {
+			mu.Lock()
+			followUp = r.Header.Clone()
+			mu.Unlock()
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		fmt.Fprint(w, `{"next":"/status"}`)
+	}))
+	defer srv.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{
+		"endpoint": %q,
+		"headers": {"X-Static": "static"},
+		"auth": {"type": "bearer", "token": "secret"},
+		"idempotency_key_header": "Idempotency-Key",
+		"record_count_header": "X-Record-Count",
+		"checksum_header": "X-Checksum",
+		"event_time": {"field": "ts"},
+		"cursor": {"path": "$.next", "interval": "1ms"}
+	}`, srv.URL+"/ingest"))
+	if _, err := send(context.Background(), s, state, testBatch(`{"ts":"2026-01-02T03:04:05Z"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if followUp == nil {
+		t.Fatal("cursor was not followed")
+	}
+	for name, want := range map[string]string{"X-Static": "static", "Authorization": "Bearer secret"} {
+		if got := followUp.Get(name); got != want {
+			t.Errorf("follow-up %s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"Idempotency-Key", "X-Record-Count", "X-Checksum", "X-Event-Time-Min", "X-Event-Time-Max"} {
+		if got := followUp.Get(name); got != "" {
+			t.Errorf("follow-up carries %s: %q", name, got)
+		}
+	}
+}
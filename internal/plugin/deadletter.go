@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// DeadLetterConfig forwards batches that failed permanently to a separate
+// endpoint for later inspection. The batch is still acked as failed.
+type DeadLetterConfig struct {
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+	Timeout  string            `json:"timeout"` // e.g., "10s"
+}
+
+// newDeadLetterTarget returns nil when dead-lettering is disabled.
+func newDeadLetterTarget(cfg DeadLetterConfig) (*callbackTarget, error) {
+	return newCallbackTarget("dead_letter", cfg.Endpoint, cfg.Headers, cfg.Timeout)
+}
+
+// deadLetter is the body posted to the dead-letter endpoint. Records are
+// indexed as in the original batch; records dropped by redaction are null.
+type deadLetter struct {
+	SessionID     string    `json:"session_id"`
+	TenantID      string    `json:"tenant_id"`
+	Endpoint      string    `json:"endpoint,omitempty"`  // URL the batch was sent to
+	Endpoints     []string  `json:"endpoints,omitempty"` // set instead when its requests went to several
+	Error         string    `json:"error"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Attempts      int       `json:"attempts"`
+	FailedAt      time.Time `json:"failed_at"`
+	FailedIndices []int     `json:"failed_indices,omitempty"` // set when only some records failed
+	Records       []any     `json:"records"`
+}
+
+// forwardDeadLetter posts b and the reason it failed to the session's
+// dead-letter endpoint. Failures to do so are logged; the batch is acked as
+// failed either way.
+func (s *HTTPSink) forwardDeadLetter(ctx context.Context, state *sessionState, b batch.Batch, d *delivery, sendErr error) {
+	target := state.deadLetter
+	if target == nil || state.cfg.DryRun || errors.Is(ctx.Err(), context.Canceled) {
+		// A cancelled batch was abandoned rather than rejected.
+		return
+	}
+
+	dl := deadLetter{
+		SessionID:  state.id,
+		TenantID:   state.tenantID,
+		Error:      sendErr.Error(),
+		StatusCode: failureStatus(sendErr),
+		Attempts:   d.attemptCount(),
+		FailedAt:   time.Now().UTC(),
+		Records:    deadLetterRecords(state, b),
+	}
+	dl.Endpoint, dl.Endpoints = d.sentTo(state)
+	var perr *partialError
+	if errors.As(sendErr, &perr) {
+		dl.FailedIndices = perr.failed
+	}
+
+	err := target.post(ctx, state, dl)
+	if err != nil {
+		deadLettersTotal.WithLabelValues(state.tenantID, "error").Inc()
+		logger.Error().
+			Err(err).
+			AnErr("batch_error", sendErr).
+			Str("session_id", state.id).
+			Int("records", len(b.Records)).
+			Msg("Failed to forward batch to dead-letter endpoint; batch is lost")
+		return
+	}
+	deadLettersTotal.WithLabelValues(state.tenantID, "forwarded").Inc()
+	logger.Warn().
+		Str("session_id", state.id).
+		Int("records", len(b.Records)).
+		Msg("Forwarded failed batch to dead-letter endpoint")
+}
+
+// deadLetterRecords returns the records of b redacted when the session
+// redacts records, but not transformed, so they can be replayed through the
+// session. Records that cannot be redacted are null; payloads that are not
+// JSON are kept as strings.
+func deadLetterRecords(state *sessionState, b batch.Batch) []any {
+	records := make([]any, len(b.Records))
+	set := func(i int, payload []byte) {
+		if json.Valid(payload) {
+			records[i] = json.RawMessage(payload)
+		} else {
+			records[i] = string(payload)
+		}
+	}
+
+	for i, r := range b.Records {
+		if state.redactor == nil {
+			set(i, r.Payload)
+		} else if redacted, err := state.redactor.redactPayload(r.Payload); err == nil {
+			set(i, redacted)
+		}
+	}
+	return records
+}
+
+// failureStatus returns the HTTP status behind err, or zero when the
+// failure was not a status response.
+func failureStatus(err error) int {
+	var perr *partialError
+	if errors.As(err, &perr) {
+		err = perr.first
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode
+	}
+	return 0
+}
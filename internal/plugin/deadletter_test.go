@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestDeadLetterNamesRequestURL(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rejected", http.StatusBadRequest)
+	}))
+	defer ingest.Close()
+	dlq := newCaptureServer(t)
+
+	for _, tc := range []struct {
+		name    string
+		redact  string
+		records []string
+		want    []string // URLs, after the server's
+	}{
+		{"one URL", `[]`, []string{`{"t":"a"}`, `{"t":"a"}`}, []string{"/t/a?k=x"}},
+		{"several URLs", `[]`, []string{`{"t":"a"}`, `{"t":"b"}`}, []string{"/t/a?k=x", "/t/b?k=x"}},
+		{"record dropped by redaction", `[{"path": "secret"}]`, []string{`{"t":"a"`, `{"t":"a","secret":1}`}, []string{"/t/a?k=x"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, state := newTestSession(t, fmt.Sprintf(`{
+				"endpoint_template": "%s/t/{{.t}}?k=x",
+				"redact": %s,
+				"dead_letter": {"endpoint": %q}
+			}`, ingest.URL, tc.redact, dlq.URL))
+			b := testBatch(tc.records...)
+			d, err := send(context.Background(), s, state, b)
+			if err == nil {
+				t.Fatal("expected the send to fail")
+			}
+			s.forwardDeadLetter(context.Background(), state, b, d, err)
+
+			bodies := dlq.received()
+			var dl deadLetter
+			if err := json.Unmarshal([]byte(bodies[len(bodies)-1]), &dl); err != nil {
+				t.Fatal(err)
+			}
+			got := dl.Endpoints
+			if dl.Endpoint != "" {
+				got = []string{dl.Endpoint}
+			}
+			want := make([]string, len(tc.want))
+			for i, u := range tc.want {
+				want[i] = ingest.URL + u
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("dead letter names %q %q, want %q", dl.Endpoint, dl.Endpoints, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+const defaultDebugBodyBytes = 1024
+
+// sensitiveHeaders are masked whenever request headers are logged, in
+// addition to the session's RedactHeaders.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Amz-Security-Token",
+}
+
+// redactHeaders returns a copy of h with sensitive values masked.
+func redactHeaders(h http.Header, extra []string) http.Header {
+	out := h.Clone()
+	for _, names := range [][]string{sensitiveHeaders, extra} {
+		for _, k := range names {
+			k = http.CanonicalHeaderKey(k)
+			if _, ok := out[k]; ok {
+				out[k] = []string{"REDACTED"}
+			}
+		}
+	}
+	return out
+}
+
+// logRequest logs an outgoing request at debug level.
+func logRequest(state *sessionState, req *http.Request, body []byte) {
+	ev := logger.Debug()
+	if !ev.Enabled() {
+		return
+	}
+	extra := state.cfg.RedactHeaders
+	if name := apiKeyHeader(state.auth); name != "" {
+		extra = append(slices.Clip(extra), name)
+	}
+	ev.Str("session_id", state.id).
+		Str("method", req.Method).
+		Str("url", redactURL(maskAPIKey(state.auth, req.URL).String())).
+		Interface("headers", redactHeaders(req.Header, extra)).
+		Int("body_bytes", len(body)).
+		Str("body", string(body[:min(len(body), state.debugBodyBytes)])).
+		Msg("HTTP request")
+}
+
+// logResponse logs a response at debug level. The logged prefix of the body
+// is put back so later readers still see the whole body.
+func logResponse(state *sessionState, resp *http.Response) {
+	ev := logger.Debug()
+	if !ev.Enabled() {
+		return
+	}
+	prefix, _ := io.ReadAll(io.LimitReader(resp.Body, int64(state.debugBodyBytes)))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), resp.Body), resp.Body}
+
+	ev.Str("session_id", state.id).
+		Int("status", resp.StatusCode).
+		Interface("headers", redactHeaders(resp.Header, state.cfg.RedactHeaders)).
+		Str("body", string(prefix)).
+		Msg("HTTP response")
+}
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DedupConfig drops records the session already delivered. Records are
+// identified by the value at Key, a dot-separated field path, or by a hash
+// of the whole payload when Key is "$"; records without a value at Key are
+// always sent. The keys of the Size most recently delivered records are
+// remembered for TTL. Keys are remembered only once delivered, so records
+// of a failed batch are sent again when it is replayed.
+type DedupConfig struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"` // default 10000
+	TTL  string `json:"ttl"`  // default 10m
+}
+
+const (
+	defaultDedupSize = 10000
+	defaultDedupTTL  = 10 * time.Minute
+)
+
+// dedupCache is a bounded LRU of recently delivered record keys.
+type dedupCache struct {
+	path []string // nil to hash the payload
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   list.List // of *dedupEntry, most recent first
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// newDedupCache validates the dedup config, returning nil when none is
+// configured.
+func newDedupCache(cfg DedupConfig) (*dedupCache, error) {
+	if cfg.Key == "" {
+		if cfg.Size != 0 || cfg.TTL != "" {
+			return nil, fmt.Errorf("dedup.size and dedup.ttl require dedup.key")
+		}
+		return nil, nil
+	}
+
+	c := &dedupCache{size: defaultDedupSize, ttl: defaultDedupTTL, entries: make(map[string]*list.Element)}
+	if cfg.Key != "$" {
+		path, err := parseTransformPath(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dedup.key: %w", err)
+		}
+		c.path = path
+	}
+	if cfg.Size < 0 {
+		return nil, fmt.Errorf("dedup.size must be >= 0")
+	}
+	if cfg.Size > 0 {
+		c.size = cfg.Size
+	}
+	if cfg.TTL != "" {
+		d, err := time.ParseDuration(cfg.TTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid dedup.ttl %q", cfg.TTL)
+		}
+		c.ttl = d
+	}
+	return c, nil
+}
+
+// key returns the dedup key of a record payload, or "" when it has none.
+func (c *dedupCache) key(payload []byte) string {
+	if c.path == nil {
+		sum := sha256.Sum256(payload)
+		return string(sum[:])
+	}
+	rec, err := decodeJSON(payload)
+	if err != nil {
+		return ""
+	}
+	v, ok := lookupPath(rec, c.path)
+	if !ok || v == nil {
+		return ""
+	}
+	// Encoded, so the string "1" and the number 1 are distinct keys.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// seen reports whether key was delivered within the TTL.
+func (c *dedupCache) seen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if time.Since(el.Value.(*dedupEntry).seen) > c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+// remember records the keys of delivered records. keys holds the key of
+// each record sent, "" for records without one, and err is the outcome of
+// sending them: records it reports as failed are not remembered.
+func (c *dedupCache) remember(keys []string, err error) {
+	var failed []int
+	if err != nil {
+		var perr *partialError
+		if !errors.As(err, &perr) {
+			return
+		}
+		failed = perr.failed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for i, key := range keys {
+		if key == "" || slices.Contains(failed, i) {
+			continue
+		}
+		if el, ok := c.entries[key]; ok {
+			el.Value.(*dedupEntry).seen = now
+			c.order.MoveToFront(el)
+			continue
+		}
+		c.entries[key] = c.order.PushFront(&dedupEntry{key: key, seen: now})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*dedupEntry).key)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// deliverByKey is the gRPC metadata key carrying a freshness deadline for
+// the batches of a Write stream, as an RFC 3339 timestamp. Batches not
+// delivered by then are failed as expired rather than sent stale.
+const deliverByKey = "planx-deliver-by"
+
+// errExpired is returned for batches whose deliver-by deadline passed.
+var errExpired = errors.New("expired")
+
+type deliverByCtxKey struct{}
+
+// withDeliverBy attaches the deliver-by deadline in the incoming metadata of
+// ctx, if any, to ctx. It is kept as a value rather than a context deadline
+// so that it survives sends detached from the stream.
+func withDeliverBy(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	v := md.Get(deliverByKey)
+	if len(v) == 0 {
+		return ctx, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s metadata %q: must be an RFC 3339 timestamp", deliverByKey, v[0])
+	}
+	return context.WithValue(ctx, deliverByCtxKey{}, t), nil
+}
+
+// deliverBy returns the deliver-by deadline attached to ctx.
+func deliverBy(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(deliverByCtxKey{}).(time.Time)
+	return t, ok
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DialerConfig controls how connections are established and recycled.
+// KeepAlive is the TCP keep-alive period (default 30s; negative disables
+// keep-alives). ConnMaxLifetime periodically closes idle connections so the
+// endpoint's DNS name is resolved again, e.g. "5m"; connections in use are
+// closed once they next become idle.
+type DialerConfig struct {
+	KeepAlive       string `json:"keep_alive"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+}
+
+func (c DialerConfig) enabled() bool {
+	return c != DialerConfig{}
+}
+
+const (
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newDialer returns the dialer for the session's connections.
+func newDialer(cfg DialerConfig) (*net.Dialer, error) {
+	d := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAlive}
+	if cfg.KeepAlive != "" {
+		keepAlive, err := time.ParseDuration(cfg.KeepAlive)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dialer.keep_alive: %w", err)
+		}
+		if keepAlive == 0 {
+			return nil, fmt.Errorf("dialer.keep_alive must be non-zero; use a negative value to disable keep-alives")
+		}
+		d.KeepAlive = keepAlive
+	}
+	return d, nil
+}
+
+// parseConnMaxLifetime returns zero when idle connections are not recycled.
+func parseConnMaxLifetime(cfg DialerConfig) (time.Duration, error) {
+	if cfg.ConnMaxLifetime == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(cfg.ConnMaxLifetime)
+	if err != nil {
+		return 0, fmt.Errorf("invalid dialer.conn_max_lifetime: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("dialer.conn_max_lifetime must be > 0, got %s", cfg.ConnMaxLifetime)
+	}
+	return d, nil
+}
+
+// idleCloser is implemented by http.Transport and http2.Transport.
+type idleCloser interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// recyclingTransport closes the idle connections of its transport every
+// lifetime until closed, so new connections re-resolve the endpoint.
+type recyclingTransport struct {
+	idleCloser
+	done chan struct{}
+	once sync.Once
+}
+
+func newRecyclingTransport(t idleCloser, lifetime time.Duration) *recyclingTransport {
+	rt := &recyclingTransport{idleCloser: t, done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(lifetime)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.CloseIdleConnections()
+			case <-rt.done:
+				return
+			}
+		}
+	}()
+	return rt
+}
+
+// Close stops recycling. It is called when the last session using the
+// transport's client is released.
+func (rt *recyclingTransport) Close() error {
+	rt.once.Do(func() { close(rt.done) })
+	return nil
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// begin registers an in-flight batch. It returns false once the session is
+// closing, in which case the batch must be rejected.
+func (st *sessionState) begin() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closing {
+		return false
+	}
+	st.pending++
+	st.inflight.Add(1)
+	return true
+}
+
+// end marks an in-flight batch registered by begin as complete.
+func (st *sessionState) end() {
+	st.mu.Lock()
+	st.pending--
+	st.mu.Unlock()
+	st.inflight.Done()
+}
+
+// stop marks the session closing and returns the number of batches still
+// in flight or buffered.
+func (st *sessionState) stop() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closing = true
+	return st.pending
+}
+
+// pendingBatches returns the number of batches still in flight or buffered.
+func (st *sessionState) pendingBatches() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.pending
+}
+
+// drain marks the session closing and waits for in-flight batches, up to
+// the session's drain timeout or until ctx is done.
+func (st *sessionState) drain(ctx context.Context) error {
+	st.stop()
+
+	done := make(chan struct{})
+	go func() {
+		st.inflight.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(st.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("drain timed out after %s with batches still in flight", st.drainTimeout)
+	case <-ctx.Done():
+		return fmt.Errorf("drain interrupted: %w", ctx.Err())
+	}
+}
+
+// ShutdownResult reports the batches pending when Shutdown was called.
+type ShutdownResult struct {
+	// Completed batches finished sending, successfully or not, and were
+	// acked.
+	Completed int
+	// Dropped batches were still in flight when the deadline passed.
+	Dropped int
+}
+
+// Shutdown stops all open sessions accepting batches, sends the batches
+// buffered for aggregation and waits for in-flight sends until ctx is done.
+// Sessions stay registered so their streams can still deliver acks.
+func (s *HTTPSink) Shutdown(ctx context.Context) ShutdownResult {
+	states := s.activeStates()
+	total := 0
+	var wg sync.WaitGroup
+	for _, state := range states {
+		total += state.stop()
+		if state.agg != nil {
+			go s.flushAggregate(state)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state.inflight.Wait()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	var res ShutdownResult
+	for _, state := range states {
+		res.Dropped += state.pendingBatches()
+	}
+	res.Completed = total - res.Dropped
+	return res
+}
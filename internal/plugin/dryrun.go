@@ -0,0 +1,18 @@
+package plugin
+
+import (
+	"net/http"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// logDryRun logs the request a dry-run session would have sent.
+func logDryRun(state *sessionState, req *http.Request, size int) {
+	logger.Info().
+		Str("session_id", state.id).
+		Str("method", req.Method).
+		Str("url", redactURL(req.URL.String())).
+		Int("bytes", size).
+		Interface("headers", redactHeaders(req.Header, state.cfg.RedactHeaders)).
+		Msg("Dry run: request not sent")
+}
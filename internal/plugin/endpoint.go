@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// endpointGroup is a sub-batch of records destined for the same URL, with
+// the same method.
+type endpointGroup struct {
+	method  string
+	url     string
+	batch   batch.Batch
+	indices []int // positions of the group's records in the original batch
+}
+
+// endpointTemplate renders request URLs from the fields of a record. String
+// values are percent-encoded before rendering, so a field cannot add path
+// segments or query parameters, and a rendered URL must keep the scheme and
+// host the template spells out.
+type endpointTemplate struct {
+	name   string // setting name for errors
+	tmpl   *template.Template
+	scheme string // literal scheme of the template; "" when rendered
+	host   string // literal host of the template; "" when rendered
+}
+
+// parseEndpointTemplate compiles the template text of setting name,
+// returning nil when text is empty.
+func parseEndpointTemplate(name, text string) (*endpointTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	t := &endpointTemplate{name: name, tmpl: tmpl}
+
+	// The scheme and host are fixed when they come before the first action.
+	prefix, _, templated := strings.Cut(text, "{{")
+	scheme, rest, ok := strings.Cut(prefix, "://")
+	if !ok {
+		return t, nil
+	}
+	t.scheme = strings.ToLower(scheme)
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 && templated {
+		return t, nil
+	}
+	if end >= 0 {
+		rest = rest[:end]
+	}
+	if u, err := url.Parse(t.scheme + "://" + rest); err == nil {
+		t.host = strings.ToLower(u.Host)
+	}
+	return t, nil
+}
+
+// render returns the URL for the decoded record fields.
+func (t *endpointTemplate) render(fields any) (string, error) {
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, escapeFields(fields)); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", t.name, err)
+	}
+	rendered := sb.String()
+	u, err := url.Parse(rendered)
+	if err != nil {
+		return "", fmt.Errorf("%s rendered an invalid URL: %w", t.name, err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https" || u.Host == "":
+		return "", fmt.Errorf("%s rendered %s, not an absolute http or https URL", t.name, redactURL(rendered))
+	case t.scheme != "" && u.Scheme != t.scheme:
+		return "", fmt.Errorf("%s rendered %s, changing the scheme %s", t.name, redactURL(rendered), t.scheme)
+	case t.host != "" && strings.ToLower(u.Host) != t.host:
+		return "", fmt.Errorf("%s rendered %s, changing the host %s", t.name, redactURL(rendered), t.host)
+	}
+	return rendered, nil
+}
+
+// escapeFields returns v with every string percent-encoded but for the
+// characters unreserved in URLs, leaving v itself untouched.
+func escapeFields(v any) any {
+	switch v := v.(type) {
+	case string:
+		return escapeURLValue(v)
+	case json.Number:
+		return json.Number(escapeURLValue(string(v)))
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = escapeFields(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = escapeFields(e)
+		}
+		return out
+	}
+	return v
+}
+
+// escapeURLValue percent-encodes s for any part of a URL.
+func escapeURLValue(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// recordEndpoint returns the URL the i-th record of b should be sent to.
+// It is empty for sessions with Endpoints, whose requests are routed by
+// sendBalanced.
+func recordEndpoint(state *sessionState, b batch.Batch, i int) (string, error) {
+	if state.endpointTmpl == nil {
+		return state.cfg.Endpoint, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b.Records[i].Payload))
+	dec.UseNumber()
+
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return "", fmt.Errorf("record %d: payload is not a JSON object: %w", i, err)
+	}
+
+	u, err := state.endpointTmpl.render(fields)
+	if err != nil {
+		return "", fmt.Errorf("record %d: %w", i, err)
+	}
+	return u, nil
+}
+
+// recordRoute returns the method and URL of the i-th record of b.
+func recordRoute(state *sessionState, b batch.Batch, i int) (method, url string, err error) {
+	if state.operations != nil {
+		return state.operations.route(state, b, i)
+	}
+	url, err = recordEndpoint(state, b, i)
+	return state.cfg.Method, url, err
+}
+
+// groupByEndpoint splits b by rendered endpoint and, with Operations, by
+// method, preserving the order in which endpoints and records first
+// appear. Without either the whole batch forms a single group. Groups are
+// then split to hold at most MaxRecordsPerRequest records.
+func groupByEndpoint(state *sessionState, b batch.Batch) ([]endpointGroup, error) {
+	if state.endpointTmpl == nil && state.operations == nil {
+		indices := make([]int, len(b.Records))
+		for i := range indices {
+			indices[i] = i
+		}
+		return splitGroups([]endpointGroup{{method: state.cfg.Method, url: state.cfg.Endpoint, batch: b, indices: indices}}, state.cfg.MaxRecordsPerRequest), nil
+	}
+
+	var groups []endpointGroup
+	byRoute := make(map[[2]string]int)
+	for i, r := range b.Records {
+		method, url, err := recordRoute(state, b, i)
+		if err != nil {
+			return nil, err
+		}
+
+		g, ok := byRoute[[2]string{method, url}]
+		if !ok {
+			g = len(groups)
+			byRoute[[2]string{method, url}] = g
+			groups = append(groups, endpointGroup{method: method, url: url})
+		}
+		groups[g].batch.Records = append(groups[g].batch.Records, r)
+		groups[g].indices = append(groups[g].indices, i)
+	}
+	return splitGroups(groups, state.cfg.MaxRecordsPerRequest), nil
+}
+
+// splitGroups splits groups with more than limit records into consecutive
+// groups of at most limit records. A limit of zero leaves groups unchanged.
+func splitGroups(groups []endpointGroup, limit int) []endpointGroup {
+	if limit <= 0 {
+		return groups
+	}
+	var out []endpointGroup
+	for _, g := range groups {
+		for len(g.indices) > limit {
+			out = append(out, endpointGroup{
+				method:  g.method,
+				url:     g.url,
+				batch:   batch.Batch{Records: g.batch.Records[:limit:limit]},
+				indices: g.indices[:limit:limit],
+			})
+			g.batch.Records, g.indices = g.batch.Records[limit:], g.indices[limit:]
+		}
+		out = append(out, g)
+	}
+	return out
+}
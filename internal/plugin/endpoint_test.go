@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestEndpointTemplateEscapesValues(t *testing.T) {
+	_, state := newTestSession(t, `{"endpoint_template": "https://api.example/v1/tenants/{{.tenant}}/events?src={{.src}}"}`)
+	for _, tc := range []struct {
+		record string
+		want   string
+	}{
+		{`{"tenant":"acme","src":"web"}`, "https://api.example/v1/tenants/acme/events?src=web"},
+		{`{"tenant":"../admin","src":"web"}`, "https://api.example/v1/tenants/..%2Fadmin/events?src=web"},
+		{`{"tenant":"a/b?c=d#e","src":"x&admin=1"}`, "https://api.example/v1/tenants/a%2Fb%3Fc%3Dd%23e/events?src=x%26admin%3D1"},
+		{`{"tenant":"a b+c","src":"u@evil"}`, "https://api.example/v1/tenants/a%20b%2Bc/events?src=u%40evil"},
+		{`{"tenant":12,"src":"web"}`, "https://api.example/v1/tenants/12/events?src=web"},
+	} {
+		got, err := recordEndpoint(state, testBatch(tc.record), 0)
+		if err != nil {
+			t.Errorf("%s: %v", tc.record, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s rendered %s, want %s", tc.record, got, tc.want)
+		}
+	}
+}
+
+func TestEndpointTemplateKeepsHost(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		template string
+		record   string
+		want     string // error, "" for success
+	}{
+		{
+			name:     "literal host",
+			template: "https://api.example/{{.path}}",
+			record:   `{"path":"@evil.example"}`,
+		},
+		{
+			name:     "templated subdomain",
+			template: "https://{{.region}}.api.example/events",
+			record:   `{"region":"eu"}`,
+		},
+		{
+			name:     "value cannot close a templated host",
+			template: "https://{{.region}}.api.example/events",
+			record:   `{"region":"evil.example/"}`,
+			want:     "invalid",
+		},
+		{
+			name:     "host injected through a trusted prefix",
+			template: "{{.base}}/events",
+			record:   `{"base":"https://evil.example"}`,
+			want:     "not an absolute http or https URL",
+		},
+		{
+			name:     "literal port",
+			template: "https://api.example:8443/{{.path}}",
+			record:   `{"path":"x"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, state := newTestSession(t, `{"endpoint_template": `+strconv.Quote(tc.template)+`}`)
+			_, err := recordEndpoint(state, testBatch(tc.record), 0)
+			switch {
+			case tc.want == "" && err != nil:
+				t.Fatalf("recordEndpoint: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Fatalf("recordEndpoint: error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEndpointTemplateLiteralOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		template     string
+		scheme, host string
+	}{
+		{"https://API.example/{{.a}}", "https", "api.example"},
+		{"https://api.example:8443?x={{.a}}", "https", "api.example:8443"},
+		{"http://api.example/events", "http", "api.example"},
+		{"https://{{.region}}.api.example/events", "https", ""},
+		{"https://api.example{{.suffix}}/events", "https", ""},
+		{"{{.base}}/events", "", ""},
+	} {
+		tmpl, err := parseEndpointTemplate("endpoint_template", tc.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.template, err)
+		}
+		if tmpl.scheme != tc.scheme || tmpl.host != tc.host {
+			t.Errorf("%s: literal origin %q %q, want %q %q", tc.template, tmpl.scheme, tmpl.host, tc.scheme, tc.host)
+		}
+	}
+}
+
+func TestEndpointTemplateRejectsChangedOrigin(t *testing.T) {
+	for _, rendered := range []string{"http://api.example/x", "https://evil.example/x", "/x"} {
+		tmpl := &endpointTemplate{
+			name:   "endpoint_template",
+			tmpl:   template.Must(template.New("").Parse(rendered)),
+			scheme: "https",
+			host:   "api.example",
+		}
+		if _, err := tmpl.render(map[string]any{}); err == nil {
+			t.Errorf("rendering %s: expected an error", rendered)
+		}
+	}
+}
+
+func TestOperationRouteEndpointEscapesValues(t *testing.T) {
+	_, state := newTestSession(t, `{
+		"endpoint": "https://api.example/v1/users",
+		"operations": {
+			"field": "op",
+			"routes": {"d": {"method": "DELETE", "endpoint": "https://api.example/v1/users/{{.id}}"}}
+		}
+	}`)
+	method, url, err := state.operations.route(state, testBatch(`{"op":"d","id":"1/../../admin?force=true"}`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://api.example/v1/users/1%2F..%2F..%2Fadmin%3Fforce%3Dtrue"; method != "DELETE" || url != want {
+		t.Errorf("route = %s %s, want DELETE %s", method, url, want)
+	}
+}
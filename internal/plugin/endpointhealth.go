@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// PassiveHealthConfig takes endpoints of Config.Endpoints out of the
+// rotation once FailureThreshold requests failed within Window. After
+// Cooldown a single probe request is sent to an ejected endpoint, which
+// rejoins the rotation if it succeeds. Failures are failed requests and 429
+// or 5xx responses, each attempt counted once; requests shed by the circuit
+// breaker never reached the endpoint and are not counted.
+type PassiveHealthConfig struct {
+	FailureThreshold int    `json:"failure_threshold"` // 0 disables
+	Window           string `json:"window"`            // default 30s
+	Cooldown         string `json:"cooldown"`          // default 30s
+}
+
+const (
+	defaultHealthWindow   = 30 * time.Second
+	defaultHealthCooldown = 30 * time.Second
+)
+
+// endpointHealth is the passive health of one endpoint, guarded by the
+// load balancer's mu.
+type endpointHealth struct {
+	failures  []time.Time // within the window, oldest first
+	ejectedAt time.Time   // zero while in the rotation
+	probeAt   time.Time   // zero unless a probe is in flight
+}
+
+// parsePassiveHealth validates cfg into lb.
+func (lb *loadBalancer) parsePassiveHealth(cfg PassiveHealthConfig) error {
+	if cfg.FailureThreshold < 0 {
+		return fmt.Errorf("passive_health.failure_threshold must be >= 0")
+	}
+	lb.threshold = cfg.FailureThreshold
+	lb.window, lb.cooldown = defaultHealthWindow, defaultHealthCooldown
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{{"window", cfg.Window, &lb.window}, {"cooldown", cfg.Cooldown, &lb.cooldown}} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid passive_health.%s %q", f.name, f.value)
+		}
+		*f.dst = d
+	}
+	if lb.threshold > 0 {
+		lb.health = make([]endpointHealth, len(lb.urls))
+	}
+	return nil
+}
+
+type endpointStatus int
+
+const (
+	endpointUp endpointStatus = iota
+	endpointDown
+	endpointProbe // ejected, due for a probe
+)
+
+// status returns the state of endpoint i at now. Endpoints whose host
+// circuit breaker is open are down. Callers must hold mu.
+func (lb *loadBalancer) status(i int, now time.Time) endpointStatus {
+	if lb.breakers != nil {
+		if u, err := url.Parse(lb.urls[i]); err == nil {
+			if b := lb.breakers.lookup(u.Host, lb.breaker); b != nil && !b.available() {
+				return endpointDown
+			}
+		}
+	}
+	if lb.threshold == 0 {
+		return endpointUp
+	}
+
+	h := &lb.health[i]
+	switch {
+	case h.ejectedAt.IsZero():
+		return endpointUp
+	case now.Sub(h.ejectedAt) < lb.cooldown:
+		return endpointDown
+	case !h.probeAt.IsZero() && now.Sub(h.probeAt) < lb.cooldown:
+		// A probe that never completed, e.g. as the send was cancelled
+		// before it reached the endpoint, is given up after a cooldown.
+		return endpointDown
+	default:
+		return endpointProbe
+	}
+}
+
+// observe records the outcome of a request sent to endpoint.
+func (lb *loadBalancer) observe(state *sessionState, endpoint string, ok bool) {
+	if lb.threshold == 0 {
+		return
+	}
+	i := lb.index(endpoint)
+	if i < 0 {
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	h := &lb.health[i]
+	now := time.Now()
+	if !h.ejectedAt.IsZero() {
+		if h.probeAt.IsZero() {
+			return // sent before the endpoint was ejected
+		}
+		h.probeAt = time.Time{}
+		if !ok {
+			h.ejectedAt = now
+			return
+		}
+		h.ejectedAt = time.Time{}
+		h.failures = h.failures[:0]
+		endpointHealthy.WithLabelValues(state.tenantID, state.id, redactURL(endpoint)).Set(1)
+		logger.Info().
+			Str("session_id", state.id).
+			Str("endpoint", redactURL(endpoint)).
+			Msg("Endpoint probe succeeded; endpoint back in rotation")
+		return
+	}
+	if ok {
+		return
+	}
+
+	cutoff := now.Add(-lb.window)
+	n := 0
+	for n < len(h.failures) && h.failures[n].Before(cutoff) {
+		n++
+	}
+	h.failures = append(h.failures[n:], now)
+	if len(h.failures) < lb.threshold {
+		return
+	}
+	h.ejectedAt = now
+	endpointHealthy.WithLabelValues(state.tenantID, state.id, redactURL(endpoint)).Set(0)
+	logger.Warn().
+		Str("session_id", state.id).
+		Str("endpoint", redactURL(endpoint)).
+		Int("failures", len(h.failures)).
+		Dur("window", lb.window).
+		Dur("cooldown", lb.cooldown).
+		Msg("Endpoint failing; removed from rotation")
+}
+
+func (lb *loadBalancer) index(endpoint string) int {
+	for i, u := range lb.urls {
+		if u == endpoint {
+			return i
+		}
+	}
+	return -1
+}
+
+// forget removes the session's endpoint health metrics.
+func (lb *loadBalancer) forget(tenantID, sessionID string) {
+	if lb.threshold == 0 {
+		return
+	}
+	for _, u := range lb.urls {
+		endpointHealthy.DeleteLabelValues(tenantID, sessionID, redactURL(u))
+	}
+}
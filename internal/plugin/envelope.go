@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// envelopeData is the data the envelope template is evaluated against.
+// Records is the batch as a JSON array, e.g. {"items":{{.Records}}}.
+type envelopeData struct {
+	TenantId  string
+	SessionId string
+	Count     int
+	Records   string
+}
+
+// validateEnvelope checks the envelope settings, which wrap json_array
+// bodies only.
+func validateEnvelope(cfg Config) error {
+	if cfg.EnvelopeKey == "" && cfg.EnvelopeTemplate == "" {
+		if len(cfg.EnvelopeFields) > 0 {
+			return fmt.Errorf("envelope_fields requires envelope_key")
+		}
+		return nil
+	}
+	if !hasBody(cfg.Method) {
+		return fmt.Errorf("envelope_key and envelope_template cannot be used with the bodyless %s method", cfg.Method)
+	}
+	switch cfg.BatchFormat {
+	case "", "json_array":
+	default:
+		return fmt.Errorf("envelope_key and envelope_template wrap a JSON array and require the json_array batch format, not %s", cfg.BatchFormat)
+	}
+	if cfg.EnvelopeTemplate != "" {
+		if cfg.EnvelopeKey != "" || len(cfg.EnvelopeFields) > 0 {
+			return fmt.Errorf("set either envelope_template or envelope_key and envelope_fields, not both")
+		}
+		if cfg.StreamBody {
+			return fmt.Errorf("envelope_template cannot be used with stream_body; use envelope_key")
+		}
+		return nil
+	}
+	for name, value := range cfg.EnvelopeFields {
+		if name == cfg.EnvelopeKey {
+			return fmt.Errorf("envelope_fields cannot contain the envelope_key %q", name)
+		}
+		if !json.Valid(value) {
+			return fmt.Errorf("envelope_fields %q is not valid JSON", name)
+		}
+	}
+	return nil
+}
+
+// parseEnvelopeTemplate compiles the envelope template, returning nil when
+// none is configured.
+func parseEnvelopeTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("envelope_template").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope_template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeEnveloped writes the records of b to w as a JSON array, wrapped in
+// the session's envelope if any, with the error contract of writeJSONArray.
+func writeEnveloped(w io.Writer, state *sessionState, b batch.Batch) error {
+	cfg := state.cfg
+	switch {
+	case state.envelope != nil:
+		records := getBuffer()
+		defer putBuffer(records)
+		if err := writeJSONArray(records, b); err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		data := envelopeData{
+			TenantId:  state.tenantID,
+			SessionId: state.id,
+			Count:     len(b.Records),
+			Records:   records.String(),
+		}
+		if err := state.envelope.Execute(&body, data); err != nil {
+			return fmt.Errorf("envelope_template: %w", err)
+		}
+		if !json.Valid(body.Bytes()) {
+			return fmt.Errorf("envelope_template did not produce valid JSON")
+		}
+		w.Write(body.Bytes())
+		return nil
+
+	case cfg.EnvelopeKey != "":
+		// Fields are written in name order so bodies are reproducible.
+		io.WriteString(w, "{")
+		for _, name := range slices.Sorted(maps.Keys(cfg.EnvelopeFields)) {
+			k, _ := json.Marshal(name)
+			w.Write(k)
+			io.WriteString(w, ":")
+			w.Write(bytes.TrimSpace(cfg.EnvelopeFields[name]))
+			io.WriteString(w, ",")
+		}
+		k, _ := json.Marshal(cfg.EnvelopeKey)
+		w.Write(k)
+		io.WriteString(w, ":")
+		if err := writeJSONArray(w, b); err != nil {
+			return err
+		}
+		io.WriteString(w, "}")
+		return nil
+
+	default:
+		return writeJSONArray(w, b)
+	}
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// statusError is returned when the endpoint responds with a failure status.
+type statusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // zero when the response carried no usable Retry-After
+	Retriable  bool          // per the session's status policy
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// partialError reports which records of a batch failed to be delivered.
+// AckResponse only carries an error string, so the failed indices are part of
+// the message for the pipeline to parse.
+type partialError struct {
+	total  int
+	failed []int // record indices, in batch order
+	first  error // error of the first failed record
+}
+
+func (e *partialError) add(index int, err error) {
+	if e.first == nil {
+		e.first = err
+	}
+	e.failed = append(e.failed, index)
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d of %d records failed (indices %v): %v", len(e.failed), e.total, e.failed, e.first)
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"net/http"
+	"time"
+)
+
+// responseEvaluator decides whether the response to a request means its
+// records were delivered. It is the one place success is detected; the
+// checks run in this order and the first that fails fails the request,
+// skipping the rest:
+//
+//  1. the status policy: a status outside success_status_codes fails with
+//     the status and body, retriable per retriable_status_codes and
+//     honoring Retry-After on 429 and 503;
+//  2. retry_response_match: a matching body fails as retriable;
+//  3. response_validator: its status, then its headers in name order, then
+//     its body assertions in order;
+//  4. multi_status: on a 207, the records reported failed fail alone;
+//  5. the batch format's own report: GraphQL errors for graphql, or the
+//     rejected items for bulk with bulk_check_items.
+//
+// Checks that read the body put what they read back, so later checks and
+// response capture see all of it. Only responses passing every check are
+// captured, extracted or followed by the cursor.
+type responseEvaluator struct {
+	status statusPolicy
+	checks []responseCheck // steps 2 to 5, those configured, in order
+}
+
+// responseCheck returns the error failing a response whose status the
+// policy accepted, or nil. indices maps the request's records onto the
+// batch, nil when they are the whole batch in order.
+type responseCheck func(state *sessionState, resp *http.Response, indices []int) error
+
+// newResponseEvaluator builds the evaluator of a session from its parsed
+// settings.
+func newResponseEvaluator(cfg Config, status statusPolicy, retryMatch *retryMatcher, validator *responseValidator, multiStatus *multiStatusParser) *responseEvaluator {
+	e := &responseEvaluator{status: status}
+	if retryMatch != nil {
+		e.checks = append(e.checks, func(state *sessionState, resp *http.Response, _ []int) error {
+			return retryMatch.check(state, resp)
+		})
+	}
+	if validator != nil {
+		e.checks = append(e.checks, func(state *sessionState, resp *http.Response, _ []int) error {
+			return validator.check(state, resp)
+		})
+	}
+	if multiStatus != nil {
+		e.checks = append(e.checks, func(_ *sessionState, resp *http.Response, indices []int) error {
+			if resp.StatusCode != http.StatusMultiStatus {
+				return nil
+			}
+			return multiStatus.check(resp, indices)
+		})
+	}
+	switch {
+	case cfg.BatchFormat == "graphql":
+		e.checks = append(e.checks, func(state *sessionState, resp *http.Response, _ []int) error {
+			return checkGraphQLResponse(state, resp)
+		})
+	case cfg.BulkCheckItems:
+		e.checks = append(e.checks, func(_ *sessionState, resp *http.Response, indices []int) error {
+			return checkBulkResponse(resp, indices)
+		})
+	}
+	return e
+}
+
+// evaluate returns the error failing resp, or nil when it passes every
+// check.
+func (e *responseEvaluator) evaluate(state *sessionState, resp *http.Response, indices []int) error {
+	if !e.status.isSuccess(resp.StatusCode) {
+		body, _ := readBody(resp.Body, state.cfg.MaxResponseBytes)
+		se := &statusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			Retriable:  e.status.isRetriable(resp.StatusCode),
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				se.RetryAfter = d
+			}
+		}
+		return se
+	}
+	for _, check := range e.checks {
+		if err := check(state, resp, indices); err != nil {
+			return err
+		}
+	}
+	return nil
+}
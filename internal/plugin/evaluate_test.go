@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testResponse(status int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestResponseEvaluatorOrder(t *testing.T) {
+	const allChecks = `{
+		"endpoint": "http://ingest.invalid",
+		"retry_response_match": {"path": "$.status", "values": ["throttled"]},
+		"response_validator": {"headers": {"X-Processed": "true"}, "body": [{"path": "$.accepted", "equals": ["true"]}]},
+		"multi_status": {"mode": "json"}
+	}`
+	processed := http.Header{"X-Processed": {"true"}}
+
+	for _, tc := range []struct {
+		name   string
+		config string
+		resp   *http.Response
+		want   string // error, "" for success
+		retry  bool
+	}{
+		{
+			name:   "passes every check",
+			config: allChecks,
+			resp:   testResponse(200, processed, `{"status":"ok","accepted":true}`),
+		},
+		{
+			name:   "status policy first",
+			config: allChecks,
+			resp:   testResponse(503, nil, `{"status":"throttled"}`),
+			want:   `HTTP 503: {"status":"throttled"}`,
+			retry:  true,
+		},
+		{
+			name:   "status policy rejects without retry",
+			config: allChecks,
+			resp:   testResponse(400, nil, `bad`),
+			want:   `HTTP 400: bad`,
+		},
+		{
+			name:   "retry match before the validator",
+			config: allChecks,
+			resp:   testResponse(200, nil, `{"status":"throttled"}`),
+			want:   `HTTP 200: {"status":"throttled"}`,
+			retry:  true,
+		},
+		{
+			name:   "validator headers before its body",
+			config: allChecks,
+			resp:   testResponse(200, nil, `{"accepted":false}`),
+			want:   `HTTP 200: response_validator: header X-Processed missing`,
+		},
+		{
+			name:   "validator body",
+			config: allChecks,
+			resp:   testResponse(200, processed, `{"accepted":false}`),
+			want:   `HTTP 200: response_validator: body $.accepted is "false", want one of ["true"]`,
+		},
+		{
+			name:   "validator before multi-status",
+			config: allChecks,
+			resp:   testResponse(207, nil, `{"results":[{"status":500}]}`),
+			want:   `HTTP 207: response_validator: header X-Processed missing`,
+		},
+		{
+			name:   "multi-status after the validator",
+			config: allChecks,
+			resp:   testResponse(207, processed, `{"accepted":true,"results":[{"status":201},{"status":400,"error":"bad"}]}`),
+			want:   `1 items rejected (indices [1]): record 1: status 400: bad`,
+		},
+		{
+			name:   "graphql errors last",
+			config: `{"endpoint": "http://ingest.invalid", "batch_format": "graphql", "graphql_query": "mutation($records: [R!]!) { ingest(records: $records) }", "response_validator": {"headers": {"X-Processed": "true"}}}`,
+			resp:   testResponse(200, processed, `{"errors":[{"message":"boom"}]}`),
+			want:   `GraphQL errors: [{"message":"boom"}]`,
+		},
+		{
+			name:   "graphql not checked once the validator fails",
+			config: `{"endpoint": "http://ingest.invalid", "batch_format": "graphql", "graphql_query": "mutation($records: [R!]!) { ingest(records: $records) }", "response_validator": {"headers": {"X-Processed": "true"}}}`,
+			resp:   testResponse(200, nil, `{"errors":[{"message":"boom"}]}`),
+			want:   `HTTP 200: response_validator: header X-Processed missing`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, state := newTestSession(t, tc.config)
+			indices := []int{0, 1}
+			err := state.evaluator.evaluate(state, tc.resp, indices)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("evaluate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("evaluate: error %v, want %q", err, tc.want)
+			}
+			var se *statusError
+			if retriable := errors.As(err, &se) && se.Retriable; retriable != tc.retry {
+				t.Errorf("retriable = %v, want %v", retriable, tc.retry)
+			}
+		})
+	}
+}
+
+func TestResponseEvaluatorKeepsBody(t *testing.T) {
+	_, state := newTestSession(t, `{
+		"endpoint": "http://ingest.invalid",
+		"retry_response_match": {"path": "$.status", "values": ["throttled"]},
+		"response_validator": {"body": [{"path": "$.accepted", "equals": ["true"]}]},
+		"multi_status": {"mode": "json"}
+	}`)
+	const body = `{"status":"ok","accepted":true,"results":[{"status":201}]}`
+	resp := testResponse(207, nil, body)
+	if err := state.evaluator.evaluate(state, resp, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	if string(got) != body {
+		t.Errorf("body after evaluation = %s, want %s", got, body)
+	}
+}
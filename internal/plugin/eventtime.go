@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// EventTimeConfig sends the range of the records' event times, read from
+// the dot-separated Field, in a header pair on each batch's requests for
+// the receiver's windowing. Format is how Field is encoded: rfc3339
+// strings (default), or unix or unix_ms numbers. Records without a
+// readable event time are left out of the range with OnMissing "skip"
+// (default) or fail the batch with "error"; batches without any send no
+// headers.
+type EventTimeConfig struct {
+	Field     string `json:"field"`      // e.g. "meta.event_time"; empty disables
+	Format    string `json:"format"`     // rfc3339 (default), unix, unix_ms
+	MinHeader string `json:"min_header"` // default X-Event-Time-Min
+	MaxHeader string `json:"max_header"` // default X-Event-Time-Max
+	OnMissing string `json:"on_missing"` // skip (default) or error
+}
+
+// eventTimeRange is the parsed form of EventTimeConfig.
+type eventTimeRange struct {
+	field                string
+	path                 []string
+	format               string
+	minHeader, maxHeader string
+	failMissing          bool
+}
+
+// newEventTimeRange validates cfg, returning nil when it is disabled.
+func newEventTimeRange(cfg EventTimeConfig) (*eventTimeRange, error) {
+	if cfg.Field == "" {
+		if cfg != (EventTimeConfig{}) {
+			return nil, fmt.Errorf("event_time settings require event_time.field")
+		}
+		return nil, nil
+	}
+	path, err := parseTransformPath(cfg.Field)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event_time.field: %w", err)
+	}
+	r := &eventTimeRange{
+		field:     cfg.Field,
+		path:      path,
+		format:    cmp.Or(cfg.Format, "rfc3339"),
+		minHeader: cmp.Or(cfg.MinHeader, "X-Event-Time-Min"),
+		maxHeader: cmp.Or(cfg.MaxHeader, "X-Event-Time-Max"),
+	}
+	switch r.format {
+	case "rfc3339", "unix", "unix_ms":
+	default:
+		return nil, fmt.Errorf("unsupported event_time.format %q: must be rfc3339, unix or unix_ms", cfg.Format)
+	}
+	switch cfg.OnMissing {
+	case "", "skip":
+	case "error":
+		r.failMissing = true
+	default:
+		return nil, fmt.Errorf("unsupported event_time.on_missing %q: must be skip or error", cfg.OnMissing)
+	}
+	return r, nil
+}
+
+// apply returns header with the event time range of b set.
+func (r *eventTimeRange) apply(header http.Header, b batch.Batch) (http.Header, error) {
+	var lo, hi time.Time
+	for i, rec := range b.Records {
+		t, err := r.eventTime(rec.Payload)
+		if err != nil {
+			if r.failMissing {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+			continue
+		}
+		if lo.IsZero() || t.Before(lo) {
+			lo = t
+		}
+		if hi.IsZero() || t.After(hi) {
+			hi = t
+		}
+	}
+	if lo.IsZero() {
+		return header, nil
+	}
+	header = header.Clone()
+	header.Set(r.minHeader, lo.UTC().Format(time.RFC3339Nano))
+	header.Set(r.maxHeader, hi.UTC().Format(time.RFC3339Nano))
+	return header, nil
+}
+
+// eventTime reads the event time of a record payload.
+func (r *eventTimeRange) eventTime(payload []byte) (time.Time, error) {
+	rec, err := decodeJSON(payload)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("no event time: %w", err)
+	}
+	v, ok := lookupPath(rec, r.path)
+	if !ok || v == nil {
+		return time.Time{}, fmt.Errorf("no event time at %q", r.field)
+	}
+
+	if r.format == "rfc3339" {
+		s, ok := v.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("event time at %q is not a string", r.field)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid event time at %q: %w", r.field, err)
+		}
+		return t, nil
+	}
+
+	var n float64
+	switch v := v.(type) {
+	case json.Number:
+		n, err = v.Float64()
+	case string:
+		n, err = strconv.ParseFloat(v, 64)
+	default:
+		err = fmt.Errorf("not a number")
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid event time at %q: %w", r.field, err)
+	}
+	if r.format == "unix_ms" {
+		return time.UnixMilli(int64(n)), nil
+	}
+	return time.Unix(0, int64(n*float64(time.Second))), nil
+}
@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+	"google.golang.org/protobuf/proto"
+)
+
+// payload is an encoded request body and its content type.
+type payload struct {
+	body        []byte
+	contentType string
+	encoding    string // Content-Encoding, set once compressed
+
+	buf  *bytes.Buffer // pooled storage backing body; nil if not pooled
+	refs *atomic.Int32 // holders of buf: the payload until released, and open request bodies
+
+	// stream, when set, writes the body on demand in place of body.
+	stream func(w io.Writer) error
+}
+
+// batchFormats lists the supported values of Config.BatchFormat.
+var batchFormats = []string{"json_array", "ndjson", "form", "csv", "xml", "msgpack", "multipart", "graphql", "bulk", "protobuf"}
+
+// hasBody reports whether requests with method carry the encoded records.
+// DELETE and GET requests are sent without a body, e.g. to delete the
+// resource an endpoint template derives from each record.
+func hasBody(method string) bool {
+	return method != http.MethodDelete && method != http.MethodGet
+}
+
+// validateFormat checks the batch format and its format-specific settings.
+func validateFormat(cfg Config) error {
+	if !hasBody(cfg.Method) && (cfg.BatchFormat != "" || cfg.StreamBody || cfg.ContentType != "") {
+		return fmt.Errorf("batch_format, content_type and stream_body cannot be used with the bodyless %s method", cfg.Method)
+	}
+
+	if cfg.BatchFormat != "" && !slices.Contains(batchFormats, cfg.BatchFormat) {
+		return fmt.Errorf("unsupported batch_format %q: must be one of %s", cfg.BatchFormat, strings.Join(batchFormats, ", "))
+	}
+
+	if cfg.BatchFormat == "csv" {
+		if len(cfg.CSVColumns) == 0 {
+			return fmt.Errorf("csv_columns is required for csv batch format")
+		}
+		switch cfg.CSVLineTerminator {
+		case "", "\n", "\r\n":
+		default:
+			return fmt.Errorf("csv_line_terminator must be \"\\n\" or \"\\r\\n\"")
+		}
+	}
+
+	if cfg.StreamBody {
+		switch cfg.BatchFormat {
+		case "", "json_array", "ndjson":
+		default:
+			return fmt.Errorf("stream_body supports the json_array and ndjson batch formats only")
+		}
+	}
+
+	if cfg.BatchFormat != "ndjson" && (cfg.NDJSONDelimiter != "" || cfg.NDJSONTrailingDelimiter != nil || cfg.RecordWrapper != "") {
+		return fmt.Errorf("ndjson_delimiter, ndjson_trailing_delimiter and record_wrapper require the ndjson batch format")
+	}
+
+	if cfg.BatchFormat == "xml" {
+		for field, name := range map[string]string{
+			"xml_root_element":   cfg.XMLRootElement,
+			"xml_record_element": cfg.XMLRecordElement,
+		} {
+			if name != "" && !isXMLName(name) {
+				return fmt.Errorf("%s %q is not a valid XML element name", field, name)
+			}
+		}
+	}
+	if err := validateMultipart(cfg); err != nil {
+		return err
+	}
+	if err := validateGraphQL(cfg); err != nil {
+		return err
+	}
+	if err := validateBulk(cfg); err != nil {
+		return err
+	}
+	if err := validateEnvelope(cfg); err != nil {
+		return err
+	}
+	return validateIntegrity(cfg)
+}
+
+// encodeBatch formats all records of b according to the session's batch format.
+func encodeBatch(state *sessionState, b batch.Batch) (payload, error) {
+	cfg := state.cfg
+	if !hasBody(cfg.Method) {
+		return payload{}, nil
+	}
+	if cfg.StreamBody {
+		return streamPayload(state, b), nil
+	}
+
+	switch cfg.BatchFormat {
+	case "ndjson":
+		buf := getBuffer()
+		if err := encodeNDJSON(buf, state, b); err != nil {
+			putBuffer(buf)
+			return payload{}, err
+		}
+		return pooledPayload(buf, "application/json"), nil
+	case "form":
+		form := url.Values{}
+		for i, r := range b.Records {
+			fields, err := flatFields(r.Payload)
+			if err != nil {
+				return payload{}, fmt.Errorf("record %d: %w", i, err)
+			}
+			for k, v := range fields {
+				form.Set(fmt.Sprintf("records[%d][%s]", i, k), v)
+			}
+		}
+		return payload{body: []byte(form.Encode()), contentType: "application/x-www-form-urlencoded"}, nil
+	case "csv":
+		body, err := encodeCSV(cfg, b)
+		if err != nil {
+			return payload{}, err
+		}
+		return payload{body: body, contentType: "text/csv"}, nil
+	case "xml":
+		body, err := encodeXML(cfg, b)
+		if err != nil {
+			return payload{}, err
+		}
+		return payload{body: body, contentType: "application/xml"}, nil
+	case "msgpack":
+		body, err := encodeMsgpack(b)
+		if err != nil {
+			return payload{}, err
+		}
+		return payload{body: body, contentType: "application/msgpack"}, nil
+	case "protobuf":
+		body, err := state.protobuf.encode(b)
+		if err != nil {
+			return payload{}, err
+		}
+		return payload{body: body, contentType: "application/x-protobuf"}, nil
+	case "bulk":
+		buf := getBuffer()
+		if err := encodeBulk(buf, state, b); err != nil {
+			putBuffer(buf)
+			return payload{}, err
+		}
+		return pooledPayload(buf, "application/x-ndjson"), nil
+	case "graphql":
+		buf := getBuffer()
+		if err := encodeGraphQL(buf, cfg, b); err != nil {
+			putBuffer(buf)
+			return payload{}, err
+		}
+		return pooledPayload(buf, "application/json"), nil
+	case "multipart":
+		buf := getBuffer()
+		contentType, err := encodeMultipart(buf, state, b)
+		if err != nil {
+			putBuffer(buf)
+			return payload{}, err
+		}
+		return pooledPayload(buf, contentType), nil
+	default:
+		// JSON array (default)
+		buf := getBuffer()
+		if err := writeEnveloped(buf, state, b); err != nil {
+			putBuffer(buf)
+			return payload{}, err
+		}
+		return pooledPayload(buf, "application/json"), nil
+	}
+}
+
+// encodeRecord formats the i-th record of b for a request of its own.
+func encodeRecord(state *sessionState, b batch.Batch, i int) (payload, error) {
+	r := b.Records[i]
+	if !hasBody(state.cfg.Method) {
+		return payload{}, nil
+	}
+	switch state.cfg.BatchFormat {
+	case "", "json_array":
+		if state.cfg.EnvelopeKey != "" || state.envelope != nil {
+			return encodeBatch(state, batch.Batch{Records: b.Records[i : i+1]})
+		}
+		return payload{body: r.Payload, contentType: "application/json"}, nil
+	case "form":
+		fields, err := flatFields(r.Payload)
+		if err != nil {
+			return payload{}, fmt.Errorf("record %d: %w", i, err)
+		}
+		form := url.Values{}
+		for k, v := range fields {
+			form.Set(k, v)
+		}
+		return payload{body: []byte(form.Encode()), contentType: "application/x-www-form-urlencoded"}, nil
+	case "protobuf":
+		m, err := state.protobuf.convert(i, r.Payload)
+		if err != nil {
+			return payload{}, err
+		}
+		body, err := proto.Marshal(m)
+		if err != nil {
+			return payload{}, fmt.Errorf("record %d: %w", i, err)
+		}
+		return payload{body: body, contentType: "application/x-protobuf"}, nil
+	default:
+		return encodeBatch(state, batch.Batch{Records: b.Records[i : i+1]})
+	}
+}
+
+// flatFields decodes a JSON object whose values are all scalars into string
+// form values. Nested objects and arrays are rejected rather than dropped.
+func flatFields(raw []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("payload is not a JSON object: %w", err)
+	}
+
+	fields := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch v := v.(type) {
+		case nil:
+			fields[k] = ""
+		case string:
+			fields[k] = v
+		case json.Number:
+			fields[k] = v.String()
+		case bool:
+			fields[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("field %q is not a scalar value", k)
+		}
+	}
+	return fields, nil
+}
+
+// encodeCSV writes a header row of cfg.CSVColumns followed by one row per
+// record. Missing fields become empty cells and extra fields are ignored.
+func encodeCSV(cfg Config, b batch.Batch) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = cfg.CSVLineTerminator == "\r\n"
+
+	if err := w.Write(cfg.CSVColumns); err != nil {
+		return nil, fmt.Errorf("failed to write csv header: %w", err)
+	}
+
+	row := make([]string, len(cfg.CSVColumns))
+	for i, r := range b.Records {
+		dec := json.NewDecoder(bytes.NewReader(r.Payload))
+		dec.UseNumber()
+
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("record %d: payload is not a JSON object: %w", i, err)
+		}
+
+		for c, col := range cfg.CSVColumns {
+			cell, err := csvCell(obj[col])
+			if err != nil {
+				return nil, fmt.Errorf("record %d: field %q: %w", i, col, err)
+			}
+			row[c] = cell
+		}
+		if err := w.Write(row); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write csv: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// csvCell renders a decoded JSON value as a CSV cell. Objects and arrays are
+// written as compact JSON.
+func csvCell(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/planx-lab/planx-common/logger"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+const defaultGraphQLVariable = "records"
+
+// graphQLError is returned when a GraphQL endpoint answers with a success
+// status but reports errors in the response body.
+type graphQLError struct {
+	Errors string // the response's errors array, truncated
+}
+
+func (e *graphQLError) Error() string {
+	return "GraphQL errors: " + e.Errors
+}
+
+// validateGraphQL checks the GraphQL settings, which require the graphql
+// batch format.
+func validateGraphQL(cfg Config) error {
+	if cfg.BatchFormat != "graphql" {
+		if cfg.GraphQLQuery != "" || cfg.GraphQLVariable != "" {
+			return fmt.Errorf("graphql_query and graphql_variable require the graphql batch format")
+		}
+		return nil
+	}
+	if cfg.GraphQLQuery == "" {
+		return fmt.Errorf("graphql_query is required for graphql batch format")
+	}
+	return nil
+}
+
+// encodeGraphQL writes a GraphQL request to w whose variables hold the
+// records of b as a JSON array.
+func encodeGraphQL(w io.Writer, cfg Config, b batch.Batch) error {
+	query, err := json.Marshal(cfg.GraphQLQuery)
+	if err != nil {
+		return err
+	}
+	variable, err := json.Marshal(cmp.Or(cfg.GraphQLVariable, defaultGraphQLVariable))
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(w, `{"query":`)
+	w.Write(query)
+	io.WriteString(w, `,"variables":{`)
+	w.Write(variable)
+	io.WriteString(w, ":")
+	if err := writeJSONArray(w, b); err != nil {
+		return err
+	}
+	io.WriteString(w, "}}")
+	return nil
+}
+
+// checkGraphQLResponse reports errors in a successful GraphQL response. The
+// inspected part of the body is put back in front of resp.Body so it can
+// still be captured.
+func checkGraphQLResponse(state *sessionState, resp *http.Response) error {
+	body, truncated := peekBody(resp, maxExtractBytes)
+
+	if truncated {
+		// The endpoint accepted the request; failing it would resend data
+		// that was likely delivered.
+		logger.Warn().
+			Str("session_id", state.id).
+			Int("limit", maxExtractBytes).
+			Msg("GraphQL response too large to check for errors")
+		return nil
+	}
+
+	var gr struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &gr); err != nil {
+		return fmt.Errorf("GraphQL response is not valid JSON: %w", err)
+	}
+	switch string(bytes.TrimSpace(gr.Errors)) {
+	case "", "null", "[]":
+		return nil
+	}
+	errs := string(gr.Errors)
+	if len(errs) > state.cfg.MaxResponseBytes {
+		errs = errs[:state.cfg.MaxResponseBytes]
+	}
+	return &graphQLError{Errors: errs}
+}
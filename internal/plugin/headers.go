@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// headerSet holds the configured headers, split into static values and
+// templates that are rendered per batch.
+type headerSet struct {
+	static    http.Header
+	templates map[string]*template.Template
+	appended  map[string][]*template.Template // multi-value headers with templates
+}
+
+// headerData is the data header and query parameter templates are
+// evaluated against.
+type headerData struct {
+	TenantId    string
+	SessionId   string
+	RecordCount int
+	Record      map[string]any // first record of the batch, nil for empty batches
+}
+
+func newHeaderData(state *sessionState, b batch.Batch) headerData {
+	data := headerData{TenantId: state.tenantID, SessionId: state.id, RecordCount: len(b.Records)}
+	if len(b.Records) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(b.Records[0].Payload))
+		dec.UseNumber()
+		// Non-object payloads simply leave .Record empty.
+		_ = dec.Decode(&data.Record)
+	}
+	return data
+}
+
+// parseHeaders compiles header values containing "{{" as templates. Static
+// values bypass the template engine entirely. Values of multi are added to
+// the header rather than replacing it, after the value from headers.
+func parseHeaders(headers map[string]string, multi map[string][]string) (*headerSet, error) {
+	h := &headerSet{static: make(http.Header, len(headers)+len(multi))}
+	for k, v := range headers {
+		if !strings.Contains(v, "{{") {
+			h.static.Set(k, v)
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for header %q: %w", k, err)
+		}
+		if h.templates == nil {
+			h.templates = make(map[string]*template.Template)
+		}
+		h.templates[k] = tmpl
+	}
+
+	for k, values := range multi {
+		if !slices.ContainsFunc(values, func(v string) bool { return strings.Contains(v, "{{") }) && !h.templated(k) {
+			for _, v := range values {
+				h.static.Add(k, v)
+			}
+			continue
+		}
+		// Values are all rendered, after any Headers template, to keep
+		// their order.
+		tmpls := make([]*template.Template, len(values))
+		for i, v := range values {
+			tmpl, err := template.New(k).Option("missingkey=zero").Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for multi_value_headers %q[%d]: %w", k, i, err)
+			}
+			tmpls[i] = tmpl
+		}
+		if h.appended == nil {
+			h.appended = make(map[string][]*template.Template)
+		}
+		h.appended[k] = tmpls
+	}
+	return h, nil
+}
+
+// templated reports whether the single-valued header name is a template.
+func (h *headerSet) templated(name string) bool {
+	for k := range h.templates {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// render returns the headers to send with b.
+func (h *headerSet) render(state *sessionState, b batch.Batch) (http.Header, error) {
+	if len(h.templates) == 0 && len(h.appended) == 0 {
+		return h.static, nil
+	}
+
+	data := newHeaderData(state, b)
+	out := h.static.Clone()
+	var sb strings.Builder
+	for k, tmpl := range h.templates {
+		sb.Reset()
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("failed to render header %q: %w", k, err)
+		}
+		out.Set(k, sb.String())
+	}
+	for k, tmpls := range h.appended {
+		for _, tmpl := range tmpls {
+			sb.Reset()
+			if err := tmpl.Execute(&sb, data); err != nil {
+				return nil, fmt.Errorf("failed to render header %q: %w", k, err)
+			}
+			out.Add(k, sb.String())
+		}
+	}
+	return out, nil
+}
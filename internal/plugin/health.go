@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	readinessCacheTTL = 5 * time.Second
+	readinessTimeout  = 5 * time.Second
+)
+
+// Readiness reports whether the sink can reach its destinations. Results are
+// cached briefly so frequent probes do not hammer the endpoints.
+type Readiness struct {
+	sink   *HTTPSink
+	canary string
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// NewReadiness returns a readiness check for sink. When canary is set only
+// that URL is probed; otherwise the endpoints of all active sessions are.
+func NewReadiness(sink *HTTPSink, canary string) *Readiness {
+	return &Readiness{sink: sink, canary: canary}
+}
+
+// Check sends a HEAD request to each probed endpoint and returns the first
+// connection failure. Any HTTP response counts as reachable.
+func (r *Readiness) Check(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.checkedAt.IsZero() && time.Since(r.checkedAt) < readinessCacheTTL {
+		return r.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	r.err = r.probe(ctx)
+	r.checkedAt = time.Now()
+	return r.err
+}
+
+func (r *Readiness) probe(ctx context.Context) error {
+	if r.canary != "" {
+		return headEndpoint(ctx, http.DefaultClient, r.canary)
+	}
+
+	// Every endpoint of a load-balanced session is probed. Sessions using
+	// endpoint templates have no fixed URL to probe.
+	probed := make(map[string]bool)
+	for _, state := range r.sink.activeStates() {
+		endpoints := []string{state.cfg.Endpoint}
+		if state.balancer != nil {
+			endpoints = state.balancer.urls
+		}
+		for _, endpoint := range endpoints {
+			if endpoint == "" || probed[endpoint] {
+				continue
+			}
+			probed[endpoint] = true
+			if err := headEndpoint(ctx, state.client, endpoint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func headEndpoint(ctx context.Context, client *http.Client, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", redactURL(endpoint), err)
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("endpoint %s unreachable: %w", redactURL(endpoint), err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SelfTest checks at startup that endpoint is reachable, with the same HEAD
+// request as the readiness probe, so misconfigured DNS or TLS fails the
+// rollout rather than the first batches.
+func SelfTest(ctx context.Context, endpoint string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return headEndpoint(ctx, http.DefaultClient, endpoint)
+}
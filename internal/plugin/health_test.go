@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadinessProbesBalancedEndpoints(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{"endpoints": [{"url": %q}, {"url": %q}]}`, up.URL, down.URL))
+	s.active.Store(state.id, state)
+
+	err := NewReadiness(s, "").Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), down.URL) {
+		t.Fatalf("Check: error %v, want %s unreachable", err, down.URL)
+	}
+}
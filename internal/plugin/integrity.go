@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+)
+
+// validateIntegrity checks the record count and checksum header settings.
+func validateIntegrity(cfg Config) error {
+	if cfg.ChecksumHeader == "" {
+		if cfg.ChecksumAlgorithm != "" || cfg.ChecksumEncoding != "" {
+			return fmt.Errorf("checksum_algorithm and checksum_encoding require checksum_header")
+		}
+		return nil
+	}
+	switch cfg.ChecksumAlgorithm {
+	case "", "sha256", "md5":
+	default:
+		return fmt.Errorf("unsupported checksum_algorithm %q: must be sha256 or md5", cfg.ChecksumAlgorithm)
+	}
+	switch cfg.ChecksumEncoding {
+	case "", "hex", "base64":
+	default:
+		return fmt.Errorf("unsupported checksum_encoding %q: must be hex or base64", cfg.ChecksumEncoding)
+	}
+	if cfg.StreamBody {
+		return fmt.Errorf("checksum_header cannot be used with stream_body; the checksum needs the body encoded before sending")
+	}
+	if !hasBody(cfg.Method) {
+		return fmt.Errorf("checksum_header cannot be used with the %s method, whose requests have no body", cfg.Method)
+	}
+	return nil
+}
+
+// setIntegrityHeaders sets the record count and checksum headers of o. The
+// checksum covers the payload exactly as sent.
+func setIntegrityHeaders(cfg Config, h http.Header, o outgoing) {
+	if cfg.RecordCountHeader != "" {
+		h.Set(cfg.RecordCountHeader, strconv.Itoa(len(o.indices)))
+	}
+	if cfg.ChecksumHeader == "" {
+		return
+	}
+
+	var sum hash.Hash
+	if cfg.ChecksumAlgorithm == "md5" {
+		sum = md5.New()
+	} else {
+		sum = sha256.New()
+	}
+	sum.Write(o.payload.body)
+
+	if cfg.ChecksumEncoding == "base64" {
+		h.Set(cfg.ChecksumHeader, base64.StdEncoding.EncodeToString(sum.Sum(nil)))
+	} else {
+		h.Set(cfg.ChecksumHeader, hex.EncodeToString(sum.Sum(nil)))
+	}
+}
@@ -0,0 +1,27 @@
+package plugin
+
+import "testing"
+
+func TestValidateIntegrityErrors(t *testing.T) {
+	for i, tc := range []struct {
+		cfg  Config
+		want string // error, "" for valid
+	}{
+		{Config{Method: "POST", ChecksumHeader: "X-Checksum"}, ""},
+		{Config{Method: "POST", StreamBody: true, ChecksumHeader: "X-Checksum"},
+			"checksum_header cannot be used with stream_body; the checksum needs the body encoded before sending"},
+		{Config{Method: "GET", ChecksumHeader: "X-Checksum"},
+			"checksum_header cannot be used with the GET method, whose requests have no body"},
+		{Config{Method: "DELETE", ChecksumHeader: "X-Checksum"},
+			"checksum_header cannot be used with the DELETE method, whose requests have no body"},
+		{Config{ChecksumAlgorithm: "md5"}, "checksum_algorithm and checksum_encoding require checksum_header"},
+	} {
+		err := validateIntegrity(tc.cfg)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("case %d: %v", i, err)
+		case tc.want != "" && (err == nil || err.Error() != tc.want):
+			t.Errorf("case %d: error %v, want %q", i, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultJWTTTL = 5 * time.Minute
+
+// jwtAuth mints short-lived JWTs signed with the configured key, caching
+// each token until it is close to expiry.
+type jwtAuth struct {
+	alg      string
+	key      any // *rsa.PrivateKey, *ecdsa.PrivateKey or []byte
+	keyID    string
+	issuer   string
+	subject  string
+	audience string
+	ttl      time.Duration
+	header   string // empty: Authorization bearer token
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newJWTAuth(cfg AuthConfig) (*jwtAuth, error) {
+	a := &jwtAuth{
+		alg:      cfg.Algorithm,
+		keyID:    cfg.KeyID,
+		issuer:   cfg.Issuer,
+		subject:  cfg.Subject,
+		audience: cfg.Audience,
+		ttl:      defaultJWTTTL,
+		header:   cfg.JWTHeader,
+	}
+	if cfg.TTL != "" {
+		d, err := time.ParseDuration(cfg.TTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid auth.ttl %q", cfg.TTL)
+		}
+		a.ttl = d
+	}
+
+	switch cfg.Algorithm {
+	case "HS256":
+		if cfg.JWTSecret == "" {
+			return nil, fmt.Errorf("auth.jwt_secret is required for HS256")
+		}
+		a.key = []byte(cfg.JWTSecret)
+	case "RS256", "ES256":
+		key, err := parsePrivateKey(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if cfg.Algorithm != "RS256" {
+				return nil, fmt.Errorf("auth.private_key_pem is an RSA key, which cannot be used with %s", cfg.Algorithm)
+			}
+		case *ecdsa.PrivateKey:
+			if cfg.Algorithm != "ES256" || k.Curve != elliptic.P256() {
+				return nil, fmt.Errorf("auth.private_key_pem must be a P-256 EC key for ES256")
+			}
+		default:
+			return nil, fmt.Errorf("auth.private_key_pem has unsupported key type %T", key)
+		}
+		a.key = key
+	default:
+		return nil, fmt.Errorf("unsupported auth.algorithm %q: must be one of RS256, ES256, HS256", cfg.Algorithm)
+	}
+	return a, nil
+}
+
+func parsePrivateKey(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("auth.private_key_pem contains no PEM block")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("auth.private_key_pem has unsupported key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("auth.private_key_pem is not a PKCS#8, PKCS#1 or SEC 1 private key")
+}
+
+func (a *jwtAuth) apply(_ context.Context, req *http.Request) error {
+	token, err := a.currentToken()
+	if err != nil {
+		return fmt.Errorf("auth failed: %w", err)
+	}
+	if a.header != "" {
+		req.Header.Set(a.header, token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func (a *jwtAuth) currentToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	margin := min(tokenRefreshMargin, a.ttl/2)
+	if a.token != "" && time.Until(a.expires) > margin {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token, err := a.sign(now)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.expires = token, now.Add(a.ttl)
+	return token, nil
+}
+
+// sign builds and signs a token issued at now.
+func (a *jwtAuth) sign(now time.Time) (string, error) {
+	header := map[string]string{"alg": a.alg, "typ": "JWT"}
+	if a.keyID != "" {
+		header["kid"] = a.keyID
+	}
+	claims := map[string]any{
+		"iat": now.Unix(),
+		"exp": now.Add(a.ttl).Unix(),
+		"jti": uuid.NewString(),
+	}
+	for k, v := range map[string]string{"iss": a.issuer, "sub": a.subject, "aud": a.audience} {
+		if v != "" {
+			claims[k] = v
+		}
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	var sig []byte
+	switch key := a.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed-width r || s encoding rather than ASN.1.
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// requestDurationBuckets spans sub-second to multi-second request latencies.
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of outgoing HTTP requests.",
+		Buckets:   requestDurationBuckets,
+	}, []string{"method", "tenant_id"})
+
+	responsesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "responses_total",
+		Help:      "Outgoing HTTP requests by response status class (2xx, 4xx, ..., or error).",
+	}, []string{"method", "tenant_id", "status_class"})
+
+	bytesSentTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "bytes_sent_total",
+		Help:      "Request body bytes sent to HTTP endpoints.",
+	}, []string{"method", "tenant_id"})
+
+	activeSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "active_sessions",
+		Help:      "Number of open sink sessions.",
+	}, []string{"tenant_id"})
+
+	deadLettersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "dead_letters_total",
+		Help:      "Failed batches posted to dead-letter endpoints, by result (forwarded or error).",
+	}, []string{"tenant_id", "result"})
+
+	schemaViolationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "schema_violations_total",
+		Help:      "Records that violated record_schema, by action taken (fail, drop or dead_letter).",
+	}, []string{"tenant_id", "action"})
+
+	duplicatesDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "duplicates_dropped_total",
+		Help:      "Records dropped as already delivered by dedup.",
+	}, []string{"tenant_id"})
+
+	receiptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "receipts_total",
+		Help:      "Delivery receipts by result (posted, error or dropped).",
+	}, []string{"tenant_id", "result"})
+
+	concurrencyLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "concurrency_limit",
+		Help:      "Current adaptive concurrency limit per session.",
+	}, []string{"tenant_id", "session_id"})
+
+	endpointHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "endpoint_healthy",
+		Help:      "Passive health of load-balanced endpoints per session: 1 in rotation, 0 removed.",
+	}, []string{"tenant_id", "session_id", "endpoint"})
+
+	poolConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "connections",
+		Help:      "Open connections per dialed host, by state (active or idle).",
+	}, []string{"host", "state"})
+
+	connAcquisitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "connection_acquisitions_total",
+		Help:      "Connections obtained for requests per host, by whether an idle one was reused; only with transport.track_conn_reuse.",
+	}, []string{"host", "reused"})
+
+	retryBudgetRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "retry_budget_remaining",
+		Help:      "Retries a session may still make within its retry budget window.",
+	}, []string{"tenant_id", "session_id"})
+
+	retryBudgetExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "retry_budget_exhausted_total",
+		Help:      "Retries not made because the session's retry budget was spent.",
+	}, []string{"tenant_id"})
+
+	tenantInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "tenant_in_flight_requests",
+		Help:      "Requests in flight per tenant under a bulkhead.",
+	}, []string{"tenant_id"})
+
+	queuedBatches = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "queued_batches",
+		Help:      "Batches of a session received but not yet acked, under max_queued_batches.",
+	}, []string{"tenant_id", "session_id"})
+
+	queueRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "planx",
+		Subsystem: "http_sink",
+		Name:      "queue_full_rejections_total",
+		Help:      "Batches failed because their session's queue was full.",
+	}, []string{"tenant_id"})
+)
+
+// observeRequest records the outcome of a single HTTP request. statusCode is
+// zero when the request failed before a response was received.
+func observeRequest(method, tenantID string, statusCode, bytes int, elapsed time.Duration) {
+	requestDuration.WithLabelValues(method, tenantID).Observe(elapsed.Seconds())
+	bytesSentTotal.WithLabelValues(method, tenantID).Add(float64(bytes))
+
+	class := "error"
+	if statusCode > 0 {
+		class = strconv.Itoa(statusCode/100) + "xx"
+	}
+	responsesTotal.WithLabelValues(method, tenantID, class).Inc()
+}
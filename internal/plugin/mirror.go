@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/planx-lab/planx-common/logger"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// MirrorConfig is an additional endpoint every batch is copied to. Mirrors
+// receive only their own headers: the session's headers and auth are meant
+// for the primary endpoint and are not forwarded.
+type MirrorConfig struct {
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+}
+
+// mirrorTarget is the validated form of MirrorConfig.
+type mirrorTarget struct {
+	url    string
+	header http.Header
+}
+
+// newMirrorTargets validates the mirror settings, returning nil when no
+// mirrors are configured.
+func newMirrorTargets(cfg Config) ([]mirrorTarget, error) {
+	if len(cfg.Mirrors) == 0 {
+		if cfg.MirrorPolicy != "" {
+			return nil, fmt.Errorf("mirror_policy requires mirrors")
+		}
+		return nil, nil
+	}
+	switch cfg.MirrorPolicy {
+	case "", "primary_only_acks", "all_must_succeed":
+	default:
+		return nil, fmt.Errorf("unsupported mirror_policy %q: must be primary_only_acks or all_must_succeed", cfg.MirrorPolicy)
+	}
+	if !hasBody(cfg.Method) {
+		return nil, fmt.Errorf("mirrors cannot be used with the bodyless %s method", cfg.Method)
+	}
+
+	targets := make([]mirrorTarget, 0, len(cfg.Mirrors))
+	for i, m := range cfg.Mirrors {
+		u, err := url.Parse(m.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid mirrors[%d].endpoint %q: must be an absolute http(s) URL", i, redactURL(m.Endpoint))
+		}
+		header := make(http.Header)
+		for k, v := range m.Headers {
+			header.Set(k, v)
+		}
+		targets = append(targets, mirrorTarget{url: m.Endpoint, header: header})
+	}
+	return targets, nil
+}
+
+// deliverMirrored sends b to the primary endpoint and every mirror
+// concurrently. Under all_must_succeed the batch fails if any send fails;
+// otherwise mirror sends continue in the background and their failures are
+// only logged.
+func (s *HTTPSink) deliverMirrored(ctx context.Context, state *sessionState, b batch.Batch, d *delivery) error {
+	if state.cfg.MirrorPolicy != "all_must_succeed" {
+		for _, m := range state.mirrors {
+			if !state.begin() {
+				break
+			}
+			// Detached so the primary ack does not wait for mirrors; the
+			// session drains them on close.
+			go func() {
+				defer state.end()
+				if err := s.sendMirror(context.WithoutCancel(ctx), state, m, b); err != nil {
+					logMirrorError(state, m, err)
+				}
+			}()
+		}
+		return s.deliverPrimary(ctx, state, b, d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(state.mirrors))
+	for i, m := range state.mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = s.sendMirror(ctx, state, m, b); errs[i] != nil {
+				logMirrorError(state, m, errs[i])
+				cancel()
+			}
+		}()
+	}
+	err := s.deliverPrimary(ctx, state, b, d)
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	for i, merr := range errs {
+		if merr != nil {
+			return fmt.Errorf("mirror %s: %w", redactURL(state.mirrors[i].url), merr)
+		}
+	}
+	return nil
+}
+
+// sendMirror sends the whole of b to m in one request.
+func (s *HTTPSink) sendMirror(ctx context.Context, state *sessionState, m mirrorTarget, b batch.Batch) error {
+	if state.requestDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, state.requestDeadline)
+		defer cancel()
+	}
+	p, err := encodeBatch(state, b)
+	if err != nil {
+		return err
+	}
+	if err := checkBodySize(state, p, b, nil); err != nil {
+		return err
+	}
+	indices := make([]int, len(b.Records))
+	for i := range indices {
+		indices[i] = i
+	}
+	// Mirror responses are not reported in the ack.
+	var d delivery
+	return s.sendWithRetry(ctx, state, &d, outgoing{method: state.cfg.Method, url: m.url, header: m.header, payload: p, indices: indices, mirror: true})
+}
+
+func logMirrorError(state *sessionState, m mirrorTarget, err error) {
+	logger.Warn().
+		Err(err).
+		Str("session_id", state.id).
+		Str("mirror", redactURL(m.url)).
+		Msg("Failed to send batch to mirror")
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// encodeMsgpack encodes the records of b as a MessagePack array. Payloads are
+// decoded from JSON first so numbers are written in their compact binary form
+// rather than as text: integers as the smallest int or uint type holding
+// them, and numbers with a fraction or exponent as float64, even when whole.
+func encodeMsgpack(b batch.Batch) ([]byte, error) {
+	values := make([]any, len(b.Records))
+	for i, r := range b.Records {
+		v, err := decodeJSONValue(r.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		values[i] = v
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(values); err != nil {
+		return nil, fmt.Errorf("failed to encode msgpack: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJSONValue decodes raw into generic values, keeping integers as int64,
+// or uint64 above its range, instead of the float64 encoding/json would use.
+func decodeJSONValue(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+func TestMsgpackNumberTypes(t *testing.T) {
+	isInt := func(c byte) bool {
+		return msgpcode.IsFixedNum(c) || (c >= msgpcode.Uint8 && c <= msgpcode.Int64)
+	}
+	for _, tc := range []struct {
+		json  string
+		float bool
+		want  any // decoded value, compared after widening to int64, uint64 or float64
+	}{
+		{"0", false, int64(0)},
+		{"7", false, int64(7)},
+		{"-1", false, int64(-1)},
+		{"300", false, int64(300)},
+		{"-40000", false, int64(-40000)},
+		{"9223372036854775807", false, int64(math.MaxInt64)},
+		{"-9223372036854775808", false, int64(math.MinInt64)},
+		{"18446744073709551615", false, uint64(math.MaxUint64)},
+		{"1.5", true, 1.5},
+		{"2.0", true, 2.0},
+		{"1e3", true, 1000.0},
+		{"-0.25", true, -0.25},
+		{"123456789012345678901234", true, 123456789012345678901234.0},
+	} {
+		body, err := encodeMsgpack(testBatch(tc.json))
+		if err != nil {
+			t.Fatalf("encodeMsgpack(%s): %v", tc.json, err)
+		}
+		dec := msgpack.NewDecoder(bytes.NewReader(body))
+		if n, err := dec.DecodeArrayLen(); err != nil || n != 1 {
+			t.Fatalf("encodeMsgpack(%s): array of %d, %v", tc.json, n, err)
+		}
+		code, err := dec.PeekCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case tc.float && code != msgpcode.Double:
+			t.Errorf("%s encoded with code %#x, want float64 (%#x)", tc.json, code, msgpcode.Double)
+		case !tc.float && !isInt(code):
+			t.Errorf("%s encoded with code %#x, want an int type", tc.json, code)
+		}
+		v, err := dec.DecodeInterface()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := widen(v); got != tc.want {
+			t.Errorf("%s decoded as %T %v, want %T %v", tc.json, got, got, tc.want, tc.want)
+		}
+	}
+}
+
+// widen converts the sized numbers msgpack decodes to int64, uint64 or
+// float64.
+func widen(v any) any {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return rv.Uint()
+		}
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return v
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	records := []string{
+		`{"id":1,"name":"alice","active":true,"score":98.25,"tags":["a","b"],"parent":null}`,
+		`{"nested":{"deep":{"list":[1,-2,3.5,{"x":"y"}]}},"unicode":"héllo 世界","empty":{},"none":[]}`,
+		`[1,"two",3.0,false]`,
+		`"just a string"`,
+		`42`,
+	}
+	body, err := encodeMsgpack(testBatch(records...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []any
+	if err := msgpack.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("body is not valid msgpack: %v", err)
+	}
+	if len(decoded) != len(records) {
+		t.Fatalf("decoded %d records, want %d", len(decoded), len(records))
+	}
+	for i, rec := range records {
+		// Compare as JSON values, where 3.0 and 3 are the same number.
+		got, err := json.Marshal(decoded[i])
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		var want, have any
+		json.Unmarshal([]byte(rec), &want)
+		json.Unmarshal(got, &have)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("record %d round-tripped as %s, want %s", i, got, rec)
+		}
+	}
+}
+
+func TestMsgpackInvalidRecord(t *testing.T) {
+	_, err := encodeMsgpack(testBatch(`{"id":1}`, `{"id":`))
+	if err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+	if want := "record 1: payload is not valid JSON"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error = %q, want it to start with %q", err, want)
+	}
+}
+
+func TestMsgpackSmallerForNumericRecords(t *testing.T) {
+	records := make([]string, 200)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"ts":%d,"sensor":%d,"seq":%d,"temp":%d.%d,"humidity":%d,"pressure":%d,"flags":[%d,%d,%d]}`,
+			1714560000000+int64(i)*1000, i%16, i, 20+i%10, i%10, 40+i%50, 101000+i, i%2, i%3, i%5)
+	}
+	b := testBatch(records...)
+
+	packed, err := encodeMsgpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var array bytes.Buffer
+	if err := writeJSONArray(&array, b); err != nil {
+		t.Fatal(err)
+	}
+
+	ratio := float64(len(packed)) / float64(array.Len())
+	t.Logf("msgpack %d bytes, JSON array %d bytes (%.0f%%)", len(packed), array.Len(), ratio*100)
+	if ratio > 0.8 {
+		t.Errorf("msgpack body is %.0f%% of the JSON array, want at most 80%%", ratio*100)
+	}
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+const (
+	defaultMultipartFileField   = "file"
+	defaultMultipartFilename    = "record-{{.Index}}.json"
+	defaultMultipartContentType = "application/json"
+)
+
+// validateMultipart checks the multipart settings, which require the
+// multipart batch format.
+func validateMultipart(cfg Config) error {
+	if cfg.BatchFormat != "multipart" {
+		if cfg.MultipartFiles != "" || cfg.MultipartFileField != "" || cfg.MultipartFilename != "" ||
+			cfg.MultipartFileContentType != "" || len(cfg.MultipartFields) > 0 {
+			return fmt.Errorf("multipart settings require the multipart batch format")
+		}
+		return nil
+	}
+
+	switch cfg.MultipartFiles {
+	case "", "per_record", "batch":
+	default:
+		return fmt.Errorf("unsupported multipart_files %q: must be per_record or batch", cfg.MultipartFiles)
+	}
+	// The boundary is part of the Content-Type, so it cannot be overridden.
+	if cfg.ContentType != "" || hasHeader(cfg.Headers, "Content-Type") {
+		return fmt.Errorf("content_type and a Content-Type header cannot be used with the multipart batch format")
+	}
+	if _, ok := cfg.MultipartFields[cmp.Or(cfg.MultipartFileField, defaultMultipartFileField)]; ok {
+		return fmt.Errorf("multipart_fields cannot contain the file field %q", cmp.Or(cfg.MultipartFileField, defaultMultipartFileField))
+	}
+	return nil
+}
+
+// parseMultipartFilename compiles the multipart filename template, returning
+// nil unless the multipart batch format is used.
+func parseMultipartFilename(cfg Config) (*template.Template, error) {
+	if cfg.BatchFormat != "multipart" {
+		return nil, nil
+	}
+	tmpl, err := template.New("multipart_filename").Option("missingkey=zero").Parse(cmp.Or(cfg.MultipartFilename, defaultMultipartFilename))
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart_filename: %w", err)
+	}
+	return tmpl, nil
+}
+
+// encodeMultipart writes a multipart/form-data body to w: the static
+// multipart_fields followed by one file part per record, or a single part
+// holding the batch as a JSON array. It returns the body's Content-Type.
+func encodeMultipart(w io.Writer, state *sessionState, b batch.Batch) (string, error) {
+	cfg := state.cfg
+	mw := multipart.NewWriter(w)
+
+	// Sorted so bodies are reproducible.
+	for _, k := range slices.Sorted(maps.Keys(cfg.MultipartFields)) {
+		if err := mw.WriteField(k, cfg.MultipartFields[k]); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.MultipartFiles == "batch" {
+		var buf bytes.Buffer
+		if err := writeJSONArray(&buf, b); err != nil {
+			return "", err
+		}
+		data := recordWrapperData{TenantId: state.tenantID, SessionId: state.id}
+		if err := writeFilePart(mw, state, data, buf.Bytes()); err != nil {
+			return "", err
+		}
+	} else {
+		for i, r := range b.Records {
+			data := recordWrapperData{
+				TenantId:  state.tenantID,
+				SessionId: state.id,
+				Index:     i,
+				Payload:   string(r.Payload),
+			}
+			dec := json.NewDecoder(bytes.NewReader(r.Payload))
+			dec.UseNumber()
+			_ = dec.Decode(&data.Record)
+
+			if err := writeFilePart(mw, state, data, r.Payload); err != nil {
+				return "", fmt.Errorf("record %d: %w", i, err)
+			}
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return mw.FormDataContentType(), nil
+}
+
+// writeFilePart adds a file part named by the filename template.
+func writeFilePart(mw *multipart.Writer, state *sessionState, data recordWrapperData, content []byte) error {
+	var filename strings.Builder
+	if err := state.multipartFilename.Execute(&filename, data); err != nil {
+		return fmt.Errorf("multipart_filename: %w", err)
+	}
+
+	cfg := state.cfg
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(cmp.Or(cfg.MultipartFileField, defaultMultipartFileField)), escapeQuotes(filename.String())))
+	h.Set("Content-Type", cmp.Or(cfg.MultipartFileContentType, defaultMultipartContentType))
+
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(content)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// escapeQuotes escapes a Content-Disposition parameter value the way
+// mime/multipart does for its own form fields.
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
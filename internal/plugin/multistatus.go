@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxMultiStatusBytes bounds the 207 response body parsed for item results.
+const maxMultiStatusBytes = 4 << 20
+
+// MultiStatusConfig parses 207 Multi-Status responses so only the records
+// the endpoint rejected fail. With Mode "json" the body holds one result
+// per record of the request, in order, in the array at Items ("$" for a
+// top-level array). A result fails unless its StatusField is a 2xx status;
+// its MessageField describes the failure.
+type MultiStatusConfig struct {
+	Mode         string `json:"mode"`          // json; empty disables
+	Items        string `json:"items"`         // default "$.results"
+	StatusField  string `json:"status_field"`  // default "status"
+	MessageField string `json:"message_field"` // default "error"
+}
+
+// multiStatusParser is the parsed form of MultiStatusConfig.
+type multiStatusParser struct {
+	items                     []string
+	statusField, messageField string
+}
+
+// newMultiStatusParser validates cfg, returning nil when it is disabled.
+func newMultiStatusParser(cfg MultiStatusConfig) (*multiStatusParser, error) {
+	switch cfg.Mode {
+	case "":
+		if cfg != (MultiStatusConfig{}) {
+			return nil, fmt.Errorf("multi_status settings require multi_status.mode")
+		}
+		return nil, nil
+	case "json":
+	default:
+		return nil, fmt.Errorf("unsupported multi_status.mode %q (must be json)", cfg.Mode)
+	}
+
+	items, err := parseExtractPath(cmp.Or(cfg.Items, "$.results"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid multi_status.items: %w", err)
+	}
+	return &multiStatusParser{
+		items:        items,
+		statusField:  cmp.Or(cfg.StatusField, "status"),
+		messageField: cmp.Or(cfg.MessageField, "error"),
+	}, nil
+}
+
+// check parses a 207 response and reports the records whose results
+// failed, mapping the i-th result onto indices[i], with the message of each.
+// The parsed body is put back in front of resp.Body so it can still be
+// captured.
+func (p *multiStatusParser) check(resp *http.Response, indices []int) error {
+	body, truncated := peekBody(resp, maxMultiStatusBytes)
+	if truncated {
+		return fmt.Errorf("multi-status response larger than %d bytes; cannot check items", maxMultiStatusBytes)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("multi-status response is not valid JSON: %w", err)
+	}
+	v, _ = lookupPath(v, p.items)
+	results, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("multi-status response has no results array at %q", "$."+strings.Join(p.items, "."))
+	}
+	if indices != nil && len(results) != len(indices) {
+		return fmt.Errorf("multi-status response has %d results for %d records", len(results), len(indices))
+	}
+
+	ie := &itemError{}
+	var msgs []string
+	for k, r := range results {
+		result, _ := r.(map[string]any)
+		status, ok := multiStatusCode(result[p.statusField])
+		if ok && status >= 200 && status < 300 {
+			continue
+		}
+		idx := k
+		if indices != nil {
+			idx = indices[k]
+		}
+		msg := "no status"
+		if ok {
+			msg = "status " + strconv.Itoa(status)
+		}
+		if m, found := result[p.messageField]; found && m != nil {
+			if s, isString := m.(string); isString {
+				msg += ": " + s
+			} else if b, err := json.Marshal(m); err == nil {
+				msg += ": " + string(b)
+			}
+		}
+		ie.indices = append(ie.indices, idx)
+		msgs = append(msgs, fmt.Sprintf("record %d: %s", idx, msg))
+	}
+	if len(ie.indices) == 0 {
+		return nil
+	}
+	// The ack carries a single error, so it lists every rejected record.
+	ie.first = errors.New(strings.Join(msgs, "; "))
+	return ie
+}
+
+// multiStatusCode reads a result status given as a number, or as a string
+// such as "409" or "HTTP/1.1 409 Conflict".
+func multiStatusCode(v any) (int, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		return n, err == nil
+	case string:
+		if strings.HasPrefix(v, "HTTP/") {
+			_, v, _ = strings.Cut(v, " ")
+		}
+		code, _, _ := strings.Cut(v, " ")
+		n, err := strconv.Atoi(code)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// recordWrapperData is the data the record wrapper template is evaluated
+// against. Payload is the record's raw JSON, e.g. {"data":{{.Payload}}}.
+type recordWrapperData struct {
+	TenantId  string
+	SessionId string
+	Index     int
+	Payload   string
+	Record    map[string]any // nil when the payload is not a JSON object
+}
+
+// parseRecordWrapper compiles the record wrapper template, returning nil when
+// none is configured.
+func parseRecordWrapper(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("record_wrapper").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record_wrapper: %w", err)
+	}
+	return tmpl, nil
+}
+
+// encodeNDJSON writes one line per record to w, separated by the configured
+// delimiter and decorated by the record wrapper, if any. w must keep write
+// errors sticky (bytes.Buffer, bufio.Writer) as they are not checked here.
+func encodeNDJSON(w io.Writer, state *sessionState, b batch.Batch) error {
+	cfg := state.cfg
+	delim := cmp.Or(cfg.NDJSONDelimiter, "\n")
+	trailing := cfg.NDJSONTrailingDelimiter == nil || *cfg.NDJSONTrailingDelimiter
+
+	for i, r := range b.Records {
+		if i > 0 {
+			io.WriteString(w, delim)
+		}
+		if state.recordWrapper == nil {
+			w.Write(r.Payload)
+			continue
+		}
+
+		data := recordWrapperData{
+			TenantId:  state.tenantID,
+			SessionId: state.id,
+			Index:     i,
+			Payload:   string(r.Payload),
+		}
+		dec := json.NewDecoder(bytes.NewReader(r.Payload))
+		dec.UseNumber()
+		// Non-object payloads simply leave .Record empty.
+		_ = dec.Decode(&data.Record)
+
+		if err := state.recordWrapper.Execute(w, data); err != nil {
+			return fmt.Errorf("record %d: record_wrapper: %w", i, err)
+		}
+	}
+	if trailing && len(b.Records) > 0 {
+		io.WriteString(w, delim)
+	}
+	return nil
+}
+
+// writeJSONArray writes the records of b to w as a compact JSON array, with
+// the same error handling contract as encodeNDJSON.
+func writeJSONArray(w io.Writer, b batch.Batch) error {
+	scratch := getBuffer()
+	defer putBuffer(scratch)
+
+	io.WriteString(w, "[")
+	for i, r := range b.Records {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		scratch.Reset()
+		if err := json.Compact(scratch, r.Payload); err != nil {
+			return fmt.Errorf("failed to marshal batch: record %d: %w", i, err)
+		}
+		w.Write(scratch.Bytes())
+	}
+	io.WriteString(w, "]")
+	return nil
+}
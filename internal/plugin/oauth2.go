@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is refreshed.
+const tokenRefreshMargin = 30 * time.Second
+
+// oauth2Auth implements the OAuth2 client-credentials grant, caching the
+// access token for the lifetime of the session.
+type oauth2Auth struct {
+	cfg    AuthConfig
+	client *http.Client
+
+	// mu serializes token fetches so concurrent sends share one refresh.
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero when the token does not expire
+}
+
+func newOAuth2Auth(cfg AuthConfig, client *http.Client) *oauth2Auth {
+	return &oauth2Auth{cfg: cfg, client: client}
+}
+
+func (a *oauth2Auth) apply(ctx context.Context, req *http.Request) error {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("auth failed: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// accessToken returns the cached token, fetching a new one if it is missing
+// or about to expire.
+func (a *oauth2Auth) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && (a.expires.IsZero() || time.Until(a.expires) > tokenRefreshMargin) {
+		return a.token, nil
+	}
+
+	token, expiresIn, err := a.fetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	a.token = token
+	a.expires = time.Time{}
+	if expiresIn > 0 {
+		a.expires = time.Now().Add(expiresIn)
+	}
+	return a.token, nil
+}
+
+func (a *oauth2Auth) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.cfg.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", 0, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+
+	return tr.AccessToken, time.Duration(tr.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// OperationsConfig routes each record by the operation at the
+// dot-separated Field, such as the "op" of change data capture events, to
+// the method and endpoint of its entry in Routes, so a single session can
+// replicate inserts, updates and deletes to a REST API. Records are grouped
+// by method and URL and each group is sent in requests of its own.
+// Records whose operation has no route take the route named by Default, or
+// fail without one.
+type OperationsConfig struct {
+	Field   string                    `json:"field"`   // e.g. "op"; empty disables
+	Routes  map[string]OperationRoute `json:"routes"`  // operation -> route, e.g. {"d": {"method": "DELETE"}}
+	Default string                    `json:"default"` // route of records with an unknown or no operation
+}
+
+// OperationRoute is where records of an operation are sent. Method
+// defaults to the session's; DELETE and GET are sent without a body.
+// Endpoint is a template like endpoint_template, e.g.
+// "https://api/v1/users/{{.id}}", with its values percent-encoded in the
+// same way, defaulting to the session's endpoint.
+type OperationRoute struct {
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+}
+
+// operationRouter is the parsed form of OperationsConfig.
+type operationRouter struct {
+	field    string
+	path     []string
+	routes   map[string]operationRoute
+	fallback *operationRoute
+}
+
+type operationRoute struct {
+	name     string
+	method   string
+	endpoint *endpointTemplate // nil for the session's endpoint
+}
+
+// newOperationRouter validates cfg.Operations, returning nil when it is
+// disabled. cfg.Method must already be normalized.
+func newOperationRouter(cfg Config) (*operationRouter, error) {
+	oc := cfg.Operations
+	if oc.Field == "" {
+		if oc.Default != "" || len(oc.Routes) > 0 {
+			return nil, fmt.Errorf("operations settings require operations.field")
+		}
+		return nil, nil
+	}
+	if len(oc.Routes) == 0 {
+		return nil, fmt.Errorf("operations.routes is required")
+	}
+	if !hasBody(cfg.Method) {
+		return nil, fmt.Errorf("operations cannot be used with the bodyless %s method; set the method of each route instead", cfg.Method)
+	}
+	if len(cfg.Mirrors) > 0 {
+		return nil, fmt.Errorf("operations cannot be used with mirrors")
+	}
+	if cfg.BatchFormat == "graphql" || cfg.BatchFormat == "bulk" {
+		return nil, fmt.Errorf("operations cannot be used with the %s batch format, which names its own operations", cfg.BatchFormat)
+	}
+
+	path, err := parseTransformPath(oc.Field)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operations.field: %w", err)
+	}
+	r := &operationRouter{field: oc.Field, path: path, routes: make(map[string]operationRoute, len(oc.Routes))}
+
+	// Validated in name order for deterministic errors.
+	names := make([]string, 0, len(oc.Routes))
+	for name := range oc.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rc := oc.Routes[name]
+		route := operationRoute{name: name, method: cfg.Method}
+		switch m := strings.ToUpper(rc.Method); m {
+		case "":
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet:
+			route.method = m
+		default:
+			return nil, fmt.Errorf("unsupported operations.routes[%q].method %q: must be one of POST, PUT, PATCH, DELETE, GET", name, rc.Method)
+		}
+		if rc.Endpoint != "" {
+			if len(cfg.Endpoints) > 0 {
+				return nil, fmt.Errorf("operations.routes[%q].endpoint cannot be used with endpoints", name)
+			}
+			if route.endpoint, err = parseEndpointTemplate(fmt.Sprintf("operations.routes[%q].endpoint", name), rc.Endpoint); err != nil {
+				return nil, err
+			}
+		}
+		r.routes[name] = route
+	}
+
+	if oc.Default != "" {
+		route, ok := r.routes[oc.Default]
+		if !ok {
+			return nil, fmt.Errorf("operations.default %q is not a route", oc.Default)
+		}
+		r.fallback = &route
+	}
+	return r, nil
+}
+
+// route returns the method and URL of the i-th record of b.
+func (r *operationRouter) route(state *sessionState, b batch.Batch, i int) (method, url string, err error) {
+	rec, err := decodeJSON(b.Records[i].Payload)
+	if err != nil {
+		return "", "", fmt.Errorf("record %d: payload is not valid JSON: %w", i, err)
+	}
+	var op string
+	switch v, _ := lookupPath(rec, r.path); v := v.(type) {
+	case string:
+		op = v
+	case json.Number:
+		op = v.String()
+	}
+
+	route, ok := r.routes[op]
+	if !ok {
+		if r.fallback == nil {
+			return "", "", fmt.Errorf("record %d: no route for operation %q at %q", i, op, r.field)
+		}
+		route = *r.fallback
+	}
+
+	if route.endpoint == nil {
+		url, err = recordEndpoint(state, b, i)
+		return route.method, url, err
+	}
+	url, err = route.endpoint.render(rec)
+	if err != nil {
+		return "", "", fmt.Errorf("record %d: %w", i, err)
+	}
+	return route.method, url, nil
+}
+
+// encodeFor encodes b for a request with method, leaving the body of
+// bodyless methods empty.
+func encodeFor(state *sessionState, method string, b batch.Batch) (payload, error) {
+	if !hasBody(method) {
+		return payload{}, nil
+	}
+	return encodeBatch(state, b)
+}
+
+// encodeRecordFor encodes the i-th record of b for a request with method,
+// leaving the body of bodyless methods empty.
+func encodeRecordFor(state *sessionState, method string, b batch.Batch, i int) (payload, error) {
+	if !hasBody(method) {
+		return payload{}, nil
+	}
+	return encodeRecord(state, b, i)
+}
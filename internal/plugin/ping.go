@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const pingTimeout = 10 * time.Second
+
+// PingResult is the outcome of Ping.
+type PingResult struct {
+	Success    bool          `json:"success"`
+	StatusCode int           `json:"status_code,omitempty"` // zero when no response was received
+	Error      string        `json:"error,omitempty"`
+	Latency    time.Duration `json:"latency"`
+}
+
+// Ping validates a session config and sends an authenticated HEAD request to
+// its endpoint without creating a session, surfacing DNS, TLS and auth
+// problems at configuration time. Any response other than 401, 403, 407 or
+// a 5xx counts as success, since ingest endpoints commonly reject HEAD.
+// Configs that fail validation are returned as an error.
+func (s *HTTPSink) Ping(ctx context.Context, tenantID string, configJSON []byte) (*PingResult, error) {
+	state, err := s.newSessionState(tenantID, configJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.release(state.clientKey)
+
+	if state.cfg.Endpoint == "" {
+		return nil, fmt.Errorf("ping requires a fixed endpoint; endpoint_template and endpoints sessions cannot be pinged")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, state.cfg.Endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range state.headers.static {
+		req.Header[k] = v
+	}
+	setUserAgent(state, req.Header)
+
+	start := time.Now()
+	result := &PingResult{}
+	if state.auth != nil {
+		if err := state.auth.apply(ctx, req); err != nil {
+			result.Error = err.Error()
+			result.Latency = time.Since(start)
+			return result, nil
+		}
+	}
+
+	resp, err := state.client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = redactAPIKey(state.auth, err).Error()
+		return result, nil
+	}
+	drainBody(resp.Body)
+
+	result.StatusCode = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden,
+		resp.StatusCode == http.StatusProxyAuthRequired,
+		resp.StatusCode >= http.StatusInternalServerError:
+		result.Error = fmt.Sprintf("endpoint responded with HTTP %d", resp.StatusCode)
+	default:
+		result.Success = true
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer keeps unusually large buffers from pinning memory in the
+// pool after a burst of big batches. Benchmarks set it negative to measure
+// sends without the pool.
+var maxPooledBuffer = 4 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// pooledPayload returns a payload backed by buf, which is returned to the
+// pool by release.
+func pooledPayload(buf *bytes.Buffer, contentType string) payload {
+	p := payload{body: buf.Bytes(), contentType: contentType, buf: buf, refs: new(atomic.Int32)}
+	p.refs.Store(1)
+	return p
+}
+
+// release drops the payload's hold on its buffer. The buffer returns to the
+// pool once every request body reading from it has been closed too; the
+// transport may keep reading a body after RoundTrip returns.
+func (p payload) release() {
+	if p.buf != nil {
+		p.unref()
+	}
+}
+
+func (p payload) unref() {
+	if p.refs.Add(-1) == 0 {
+		putBuffer(p.buf)
+	}
+}
+
+// setBody sets the payload as the body of req. Readers of pooled payloads
+// are tracked so the buffer outlives them.
+func (p payload) setBody(req *http.Request) {
+	if p.stream != nil {
+		p.setStreamBody(req)
+		return
+	}
+	req.ContentLength = int64(len(p.body))
+	if len(p.body) == 0 {
+		req.Body, req.GetBody = http.NoBody, nil
+		return
+	}
+	req.GetBody = func() (io.ReadCloser, error) { return p.reader(), nil }
+	req.Body = p.reader()
+}
+
+func (p payload) reader() io.ReadCloser {
+	if p.refs == nil {
+		return io.NopCloser(bytes.NewReader(p.body))
+	}
+	p.refs.Add(1)
+	return &trackedReader{Reader: bytes.NewReader(p.body), done: p.unref}
+}
+
+// trackedReader reports its first Close to done.
+type trackedReader struct {
+	*bytes.Reader
+	once sync.Once
+	done func()
+}
+
+func (r *trackedReader) Close() error {
+	r.once.Do(r.done)
+	return nil
+}
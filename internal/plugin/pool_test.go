@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// discardTransport consumes request bodies and answers 200 OK without a
+// network round trip, so benchmarks measure the send path alone.
+type discardTransport struct{}
+
+func (discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func BenchmarkSendBatch(b *testing.B) {
+	records := make([]string, 500)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"id":%d,"service":"checkout","message":"processed order %d","latency":%d.5}`, i, 100000+i, i%250)
+	}
+	batch := testBatch(records...)
+
+	for _, format := range []string{"json_array", "ndjson"} {
+		for _, pooled := range []bool{true, false} {
+			name := format + "/pool"
+			if !pooled {
+				name = format + "/no_pool"
+			}
+			b.Run(name, func(b *testing.B) {
+				if !pooled {
+					defer func(n int) { maxPooledBuffer = n }(maxPooledBuffer)
+					maxPooledBuffer = -1
+				}
+				s, state := newTestSession(b, fmt.Sprintf(`{"endpoint": "http://ingest.invalid/v1", "batch_format": %q}`, format))
+				state.client.Transport = discardTransport{}
+				ctx := context.Background()
+
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := send(ctx, s, state, batch); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPooledPayloadOutlivesBody(t *testing.T) {
+	buf := getBuffer()
+	buf.WriteString(strings.Repeat("x", 1024))
+	p := pooledPayload(buf, "text/plain")
+
+	req, err := http.NewRequest(http.MethodPost, "http://ingest.invalid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setBody(req)
+	p.release()
+
+	// The buffer must not be reused while the body is still open.
+	for range 100 {
+		other := getBuffer()
+		if other == buf {
+			t.Fatal("buffer returned to the pool while a request body still read from it")
+		}
+		defer putBuffer(other)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Body.Close()
+	if len(body) != 1024 || strings.Trim(string(body), "x") != "" {
+		t.Errorf("body read %d bytes, want 1024 intact bytes", len(body))
+	}
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtobufConfig configures the protobuf batch format. Records are JSON in
+// the protobuf JSON mapping of Message, whose definition comes from a
+// FileDescriptorSet such as protoc --descriptor_set_out --include_imports
+// writes, given inline in base64 or as a file. With Packing "delimited"
+// (default) the body is the records' messages, each prefixed by its varint
+// length; with "repeated" it is one BatchMessage holding the records in its
+// repeated BatchField. Requests in per_record delivery carry the bare
+// message.
+type ProtobufConfig struct {
+	DescriptorSet     string `json:"descriptor_set"`
+	DescriptorSetFile string `json:"descriptor_set_file"`
+	Message           string `json:"message"` // full name, e.g. "acme.events.v1.Event"
+	Packing           string `json:"packing"` // delimited (default) or repeated
+	BatchMessage      string `json:"batch_message"`
+	BatchField        string `json:"batch_field"`
+}
+
+// protobufCodec is the parsed form of ProtobufConfig.
+type protobufCodec struct {
+	message protoreflect.MessageDescriptor
+	batch   protoreflect.MessageDescriptor // nil unless packing is repeated
+	field   protoreflect.FieldDescriptor   // repeated field of batch
+}
+
+// newProtobufCodec resolves the configured messages, returning nil unless
+// the batch format is protobuf.
+func newProtobufCodec(cfg Config) (*protobufCodec, error) {
+	pc := cfg.Protobuf
+	if cfg.BatchFormat != "protobuf" {
+		if pc != (ProtobufConfig{}) {
+			return nil, fmt.Errorf("protobuf settings require the protobuf batch format")
+		}
+		return nil, nil
+	}
+
+	var raw []byte
+	switch {
+	case pc.DescriptorSet != "" && pc.DescriptorSetFile != "":
+		return nil, fmt.Errorf("protobuf.descriptor_set and protobuf.descriptor_set_file are mutually exclusive")
+	case pc.DescriptorSet != "":
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(pc.DescriptorSet); err != nil {
+			return nil, fmt.Errorf("protobuf.descriptor_set is not valid base64: %w", err)
+		}
+	case pc.DescriptorSetFile != "":
+		var err error
+		if raw, err = os.ReadFile(pc.DescriptorSetFile); err != nil {
+			return nil, fmt.Errorf("failed to read protobuf.descriptor_set_file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("protobuf.descriptor_set or protobuf.descriptor_set_file is required for protobuf batch format")
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set: %w", err)
+	}
+	lookup := func(field, name string) (protoreflect.MessageDescriptor, error) {
+		if name == "" {
+			return nil, fmt.Errorf("protobuf.%s is required", field)
+		}
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("protobuf.%s %q is not in the descriptor set", field, name)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("protobuf.%s %q is not a message", field, name)
+		}
+		return md, nil
+	}
+
+	c := &protobufCodec{}
+	if c.message, err = lookup("message", pc.Message); err != nil {
+		return nil, err
+	}
+	switch pc.Packing {
+	case "", "delimited":
+		if pc.BatchMessage != "" || pc.BatchField != "" {
+			return nil, fmt.Errorf("protobuf.batch_message and protobuf.batch_field require repeated packing")
+		}
+	case "repeated":
+		if c.batch, err = lookup("batch_message", pc.BatchMessage); err != nil {
+			return nil, err
+		}
+		c.field = c.batch.Fields().ByName(protoreflect.Name(pc.BatchField))
+		if c.field == nil || c.field.Cardinality() != protoreflect.Repeated || c.field.IsMap() ||
+			c.field.Message() == nil || c.field.Message().FullName() != c.message.FullName() {
+			return nil, fmt.Errorf("protobuf.batch_field %q must be a repeated %s field of %s", pc.BatchField, c.message.FullName(), c.batch.FullName())
+		}
+	default:
+		return nil, fmt.Errorf("invalid protobuf.packing %q (must be delimited or repeated)", pc.Packing)
+	}
+	return c, nil
+}
+
+// encode converts the records of b to messages and packs them. Every record
+// is converted so the error lists all records that do not match the
+// message.
+func (c *protobufCodec) encode(b batch.Batch) ([]byte, error) {
+	msgs := make([]*dynamicpb.Message, len(b.Records))
+	var errs []error
+	for i, r := range b.Records {
+		m, err := c.convert(i, r.Payload)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		msgs[i] = m
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if c.batch != nil {
+		wrapper := dynamicpb.NewMessage(c.batch)
+		list := wrapper.Mutable(c.field).List()
+		for _, m := range msgs {
+			list.Append(protoreflect.ValueOfMessage(m))
+		}
+		return proto.Marshal(wrapper)
+	}
+
+	var buf bytes.Buffer
+	for _, m := range msgs {
+		if _, err := protodelim.MarshalTo(&buf, m); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// convert parses the i-th record into a message.
+func (c *protobufCodec) convert(i int, raw []byte) (*dynamicpb.Message, error) {
+	m := dynamicpb.NewMessage(c.message)
+	if err := protojson.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("record %d does not match %s: %w", i, c.message.FullName(), err)
+	}
+	return m, nil
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// querySet holds the configured query parameters, split like headerSet
+// into static values and templates rendered per request.
+type querySet struct {
+	static    url.Values
+	templates map[string]*template.Template
+}
+
+// parseQueryParams compiles parameter values containing "{{" as templates,
+// returning nil when no parameters are configured.
+func parseQueryParams(params map[string]string) (*querySet, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	q := &querySet{static: make(url.Values, len(params))}
+	for k, v := range params {
+		if !strings.Contains(v, "{{") {
+			q.static.Set(k, v)
+			continue
+		}
+		tmpl, err := template.New(k).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for query parameter %q: %w", k, err)
+		}
+		if q.templates == nil {
+			q.templates = make(map[string]*template.Template)
+		}
+		q.templates[k] = tmpl
+	}
+	return q, nil
+}
+
+// render returns the query parameters of a request carrying b. It is nil
+// safe, returning nil for sessions without parameters.
+func (q *querySet) render(state *sessionState, b batch.Batch) (url.Values, error) {
+	if q == nil {
+		return nil, nil
+	}
+	if len(q.templates) == 0 {
+		return q.static, nil
+	}
+
+	data := newHeaderData(state, b)
+	out := make(url.Values, len(q.static)+len(q.templates))
+	for k, v := range q.static {
+		out[k] = v
+	}
+	var sb strings.Builder
+	for k, tmpl := range q.templates {
+		sb.Reset()
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("failed to render query parameter %q: %w", k, err)
+		}
+		out.Set(k, sb.String())
+	}
+	return out, nil
+}
+
+// mergeQuery sets params in the query of u, replacing parameters of the
+// same name already present in the endpoint.
+func mergeQuery(u *url.URL, params url.Values) {
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// batchQueue bounds the batches of a session that were received but not
+// yet acked: buffered for aggregation, waiting for or holding a delivery
+// slot, or in flight.
+type batchQueue struct {
+	slots  chan struct{}
+	reject bool // fail batches arriving at a full queue instead of waiting
+}
+
+// newBatchQueue validates the queue settings of cfg, returning nil when
+// MaxQueuedBatches is unset.
+func newBatchQueue(cfg Config) (*batchQueue, error) {
+	var reject bool
+	switch cfg.QueueFullPolicy {
+	case "", "block":
+	case "reject":
+		reject = true
+	default:
+		return nil, fmt.Errorf("unsupported queue_full_policy %q: must be block or reject", cfg.QueueFullPolicy)
+	}
+	if cfg.MaxQueuedBatches < 0 {
+		return nil, fmt.Errorf("max_queued_batches must be >= 0")
+	}
+	if cfg.MaxQueuedBatches == 0 {
+		if cfg.QueueFullPolicy != "" {
+			return nil, fmt.Errorf("queue_full_policy requires max_queued_batches")
+		}
+		return nil, nil
+	}
+	return &batchQueue{slots: make(chan struct{}, cfg.MaxQueuedBatches), reject: reject}, nil
+}
+
+// enter queues a batch of the session, waiting for room unless the policy
+// rejects batches, and reports whether it was queued. It fails only when
+// ctx is done while waiting.
+func (q *batchQueue) enter(ctx context.Context, state *sessionState) (bool, error) {
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		if q.reject {
+			queueRejected.WithLabelValues(state.tenantID).Inc()
+			return false, nil
+		}
+		select {
+		case q.slots <- struct{}{}:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	queuedBatches.WithLabelValues(state.tenantID, state.id).Set(float64(len(q.slots)))
+	return true, nil
+}
+
+// leave removes an acked batch from the queue.
+func (q *batchQueue) leave(state *sessionState) {
+	<-q.slots
+	queuedBatches.WithLabelValues(state.tenantID, state.id).Set(float64(len(q.slots)))
+}
+
+// forget removes the session's queue metric.
+func (q *batchQueue) forget(tenantID, sessionID string) {
+	queuedBatches.DeleteLabelValues(tenantID, sessionID)
+}
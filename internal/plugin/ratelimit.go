@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"fmt"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig caps the request rate of a session.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second"` // 0 disables rate limiting
+	Burst             int     `json:"burst"`               // defaults to 1
+}
+
+// newRateLimiter returns a token-bucket limiter for cfg, or nil when rate
+// limiting is disabled.
+func newRateLimiter(cfg RateLimitConfig) (*rate.Limiter, error) {
+	if cfg.RequestsPerSecond < 0 || cfg.Burst < 0 {
+		return nil, fmt.Errorf("rate_limit values must be >= 0")
+	}
+	if cfg.RequestsPerSecond == 0 {
+		return nil, nil
+	}
+	return rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), max(cfg.Burst, 1)), nil
+}
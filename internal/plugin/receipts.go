@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// maxPendingReceipts bounds the receipts of a session being posted at once.
+// Further receipts are dropped rather than queued.
+const maxPendingReceipts = 64
+
+// ReceiptsConfig posts a receipt of every delivered or failed batch to a
+// monitoring endpoint, e.g. as an audit trail. Receipts are posted in the
+// background and never affect the ack. An aggregate of batches sent as one
+// request gets one receipt.
+type ReceiptsConfig struct {
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+	Timeout  string            `json:"timeout"` // e.g., "10s"
+}
+
+// receiptTarget is the validated form of ReceiptsConfig.
+type receiptTarget struct {
+	*callbackTarget
+	pending chan struct{}
+}
+
+// newReceiptTarget returns nil when receipts are disabled.
+func newReceiptTarget(cfg ReceiptsConfig) (*receiptTarget, error) {
+	t, err := newCallbackTarget("receipts", cfg.Endpoint, cfg.Headers, cfg.Timeout)
+	if t == nil || err != nil {
+		return nil, err
+	}
+	return &receiptTarget{callbackTarget: t, pending: make(chan struct{}, maxPendingReceipts)}, nil
+}
+
+// receipt is the body posted to the receipts endpoint.
+type receipt struct {
+	SessionID     string    `json:"session_id"`
+	TenantID      string    `json:"tenant_id"`
+	Endpoint      string    `json:"endpoint,omitempty"`  // URL the batch was sent to
+	Endpoints     []string  `json:"endpoints,omitempty"` // set instead when its requests went to several
+	Status        string    `json:"status"`              // delivered, partial or failed
+	Records       int       `json:"records"`
+	FailedIndices []int     `json:"failed_indices,omitempty"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Attempts      int       `json:"attempts"`
+	LatencyMs     int64     `json:"latency_ms"`
+	CompletedAt   time.Time `json:"completed_at"`
+}
+
+// emitReceipt posts the receipt of a batch of n records whose delivery took
+// elapsed and ended with sendErr. Failures to post are logged only.
+func (s *HTTPSink) emitReceipt(ctx context.Context, state *sessionState, n int, d *delivery, sendErr error, elapsed time.Duration) {
+	target := state.receipts
+	if target == nil || state.cfg.DryRun {
+		return
+	}
+
+	r := receipt{
+		SessionID:   state.id,
+		TenantID:    state.tenantID,
+		Status:      "delivered",
+		Records:     n,
+		Attempts:    d.attemptCount(),
+		LatencyMs:   elapsed.Milliseconds(),
+		CompletedAt: time.Now().UTC(),
+	}
+	r.Endpoint, r.Endpoints = d.sentTo(state)
+	if sendErr != nil {
+		r.Status = "failed"
+		r.Error = sendErr.Error()
+		r.StatusCode = failureStatus(sendErr)
+		var perr *partialError
+		if errors.As(sendErr, &perr) {
+			r.Status = "partial"
+			r.FailedIndices = perr.failed
+		}
+	}
+
+	select {
+	case target.pending <- struct{}{}:
+	default:
+		receiptsTotal.WithLabelValues(state.tenantID, "dropped").Inc()
+		logger.Warn().
+			Str("session_id", state.id).
+			Int("pending", maxPendingReceipts).
+			Msg("Too many delivery receipts pending; receipt dropped")
+		return
+	}
+	go func() {
+		defer func() { <-target.pending }()
+		if err := target.post(ctx, state, r); err != nil {
+			receiptsTotal.WithLabelValues(state.tenantID, "error").Inc()
+			logger.Warn().
+				Err(err).
+				Str("session_id", state.id).
+				Msg("Failed to post delivery receipt")
+			return
+		}
+		receiptsTotal.WithLabelValues(state.tenantID, "posted").Inc()
+	}()
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReceiptNamesBalancedEndpoint(t *testing.T) {
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ingest.Close()
+	want := ingest.URL + "/ingest"
+
+	for _, tc := range []struct {
+		name    string
+		batches [][]string // sent in order; the receipt of the last is checked
+	}{
+		{"every record sent", [][]string{{`{"id":1}`}}},
+		{"record deduplicated", [][]string{{`{"id":1}`}, {`{"id":1}`, `{"id":2}`}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receipts := newCaptureServer(t)
+			s, state := newTestSession(t, fmt.Sprintf(`{
+				"endpoints": [{"url": %q}],
+				"dedup": {"key": "id"},
+				"receipts": {"endpoint": %q}
+			}`, want, receipts.URL))
+
+			for i, records := range tc.batches {
+				b := testBatch(records...)
+				start := time.Now()
+				d, err := send(context.Background(), s, state, b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.emitReceipt(context.Background(), state, len(b.Records), d, err, time.Since(start))
+				awaitReceipts(t, receipts, i+1)
+			}
+
+			bodies := receipts.received()
+			var r receipt
+			if err := json.Unmarshal([]byte(bodies[len(bodies)-1]), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Endpoint != want || r.Endpoints != nil {
+				t.Errorf("receipt names %q %q, want %q", r.Endpoint, r.Endpoints, want)
+			}
+		})
+	}
+}
+
+// awaitReceipts waits until cs received n receipts, which are posted in the
+// background.
+func awaitReceipts(t *testing.T, cs *captureServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cs.received()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d receipts posted, want %d", len(cs.received()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
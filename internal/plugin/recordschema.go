@@ -0,0 +1,474 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/planx-lab/planx-common/logger"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// validateSchemaViolation checks the OnSchemaViolation policy.
+func validateSchemaViolation(cfg Config) error {
+	switch cfg.OnSchemaViolation {
+	case "", "fail", "drop":
+	case "dead_letter":
+		if cfg.DeadLetter.Endpoint == "" {
+			return fmt.Errorf("on_schema_violation dead_letter requires dead_letter.endpoint")
+		}
+	default:
+		return fmt.Errorf("invalid on_schema_violation %q (must be fail, drop or dead_letter)", cfg.OnSchemaViolation)
+	}
+	if cfg.OnSchemaViolation != "" && len(cfg.RecordSchema) == 0 {
+		return fmt.Errorf("on_schema_violation requires record_schema")
+	}
+	return nil
+}
+
+// handleSchemaViolations applies the OnSchemaViolation policy to the records
+// of b listed in invalid. A non-nil error fails the whole batch.
+func (s *HTTPSink) handleSchemaViolations(ctx context.Context, state *sessionState, b batch.Batch, d *delivery, invalid *partialError) error {
+	action := cmp.Or(state.cfg.OnSchemaViolation, "fail")
+	schemaViolationsTotal.WithLabelValues(state.tenantID, action).Add(float64(len(invalid.failed)))
+
+	switch action {
+	case "fail":
+		// Not wrapped: no record was sent, so this is not a partial failure.
+		return fmt.Errorf("batch not sent, %v", invalid)
+	case "dead_letter":
+		s.forwardDeadLetter(ctx, state, b, d, invalid)
+	default:
+		logger.Warn().
+			Str("session_id", state.id).
+			Ints("indices", invalid.failed).
+			AnErr("first_error", invalid.first).
+			Msg("Dropped records that violate record_schema")
+	}
+	return nil
+}
+
+// recordSchema validates records against a JSON Schema. It implements the
+// validation vocabulary commonly used for record shapes: type, enum, const,
+// properties, required, additionalProperties, items, numeric, string and
+// array bounds, pattern, allOf, anyOf, oneOf, not and local $ref into $defs
+// or definitions. Annotations such as title and format are ignored; any
+// other keyword is rejected when the schema is compiled.
+type recordSchema struct {
+	always *bool // set for the boolean schemas true and false
+
+	types      []string
+	enum       []any
+	constValue any
+	hasConst   bool
+
+	properties           map[string]*recordSchema
+	required             []string
+	additionalProperties *recordSchema
+	items                *recordSchema
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	pattern                            *regexp.Regexp
+
+	allOf, anyOf, oneOf []*recordSchema
+	not                 *recordSchema
+
+	ref  string
+	root *recordSchema
+	defs map[string]*recordSchema // "#/$defs/name" -> schema; root only
+	refs []string                 // every $ref in the document; root only
+}
+
+// schemaAnnotations are keywords that do not affect validation.
+var schemaAnnotations = []string{
+	"$schema", "$id", "$comment", "title", "description", "default", "examples",
+	"format", "deprecated", "readOnly", "writeOnly", "$defs", "definitions",
+}
+
+// compileRecordSchema compiles the RecordSchema document, returning nil when
+// none is configured.
+func compileRecordSchema(raw json.RawMessage) (*recordSchema, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	doc, err := decodeJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record_schema: %w", err)
+	}
+
+	root := &recordSchema{defs: make(map[string]*recordSchema)}
+	if obj, ok := doc.(map[string]any); ok {
+		for _, keyword := range []string{"$defs", "definitions"} {
+			defs, ok := obj[keyword].(map[string]any)
+			if !ok {
+				continue
+			}
+			for name, def := range defs {
+				s, err := compileSchemaNode(def, root, "/"+keyword+"/"+name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid record_schema: %w", err)
+				}
+				root.defs["#/"+keyword+"/"+name] = s
+			}
+		}
+	}
+	s, err := compileSchemaNode(doc, root, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid record_schema: %w", err)
+	}
+	defs, refs := root.defs, root.refs
+	*root = *s
+	root.defs, root.refs = defs, refs
+	for _, ref := range root.refs {
+		if _, ok := root.defs[ref]; !ok && ref != "#" {
+			return nil, fmt.Errorf("invalid record_schema: unresolved $ref %q", ref)
+		}
+	}
+	return root, nil
+}
+
+func compileSchemaNode(v any, root *recordSchema, at string) (*recordSchema, error) {
+	if b, ok := v.(bool); ok {
+		return &recordSchema{always: &b, root: root}, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema at %q must be an object or boolean", schemaPath(at))
+	}
+
+	s := &recordSchema{root: root}
+	sub := func(key string, v any) (*recordSchema, error) {
+		return compileSchemaNode(v, root, at+"/"+key)
+	}
+	subList := func(key string, v any) ([]*recordSchema, error) {
+		list, ok := v.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s at %q must be a non-empty array", key, schemaPath(at))
+		}
+		out := make([]*recordSchema, len(list))
+		for i, e := range list {
+			var err error
+			if out[i], err = compileSchemaNode(e, root, fmt.Sprintf("%s/%s/%d", at, key, i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	number := func(key string, v any) (*float64, error) {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s at %q must be a number", key, schemaPath(at))
+		}
+		f, err := n.Float64()
+		return &f, err
+	}
+	count := func(key string, v any) (*int, error) {
+		n, ok := v.(json.Number)
+		i, err := strconv.Atoi(string(n))
+		if !ok || err != nil || i < 0 {
+			return nil, fmt.Errorf("%s at %q must be a non-negative integer", key, schemaPath(at))
+		}
+		return &i, nil
+	}
+
+	var err error
+	for key, val := range obj {
+		switch key {
+		case "type":
+			switch t := val.(type) {
+			case string:
+				s.types = []string{t}
+			case []any:
+				for _, e := range t {
+					name, _ := e.(string)
+					s.types = append(s.types, name)
+				}
+			}
+			for _, t := range s.types {
+				if !slices.Contains([]string{"null", "boolean", "object", "array", "number", "integer", "string"}, t) {
+					return nil, fmt.Errorf("unknown type %q at %q", t, schemaPath(at))
+				}
+			}
+		case "enum":
+			list, ok := val.([]any)
+			if !ok {
+				return nil, fmt.Errorf("enum at %q must be an array", schemaPath(at))
+			}
+			s.enum = list
+		case "const":
+			s.constValue, s.hasConst = val, true
+		case "properties":
+			props, ok := val.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("properties at %q must be an object", schemaPath(at))
+			}
+			s.properties = make(map[string]*recordSchema, len(props))
+			for name, p := range props {
+				if s.properties[name], err = compileSchemaNode(p, root, at+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := val.([]any)
+			if !ok {
+				return nil, fmt.Errorf("required at %q must be an array", schemaPath(at))
+			}
+			for _, e := range list {
+				name, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("required at %q must list property names", schemaPath(at))
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			s.additionalProperties, err = sub(key, val)
+		case "items":
+			s.items, err = sub(key, val)
+		case "not":
+			s.not, err = sub(key, val)
+		case "allOf":
+			s.allOf, err = subList(key, val)
+		case "anyOf":
+			s.anyOf, err = subList(key, val)
+		case "oneOf":
+			s.oneOf, err = subList(key, val)
+		case "minimum":
+			s.minimum, err = number(key, val)
+		case "maximum":
+			s.maximum, err = number(key, val)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = number(key, val)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = number(key, val)
+		case "minLength":
+			s.minLength, err = count(key, val)
+		case "maxLength":
+			s.maxLength, err = count(key, val)
+		case "minItems":
+			s.minItems, err = count(key, val)
+		case "maxItems":
+			s.maxItems, err = count(key, val)
+		case "pattern":
+			expr, _ := val.(string)
+			if s.pattern, err = regexp.Compile(expr); err != nil {
+				err = fmt.Errorf("pattern at %q: %w", schemaPath(at), err)
+			}
+		case "$ref":
+			ref, _ := val.(string)
+			if ref != "#" && !strings.HasPrefix(ref, "#/$defs/") && !strings.HasPrefix(ref, "#/definitions/") {
+				return nil, fmt.Errorf("$ref %q at %q: only local references to $defs or definitions are supported", ref, schemaPath(at))
+			}
+			s.ref = ref
+			root.refs = append(root.refs, ref)
+		default:
+			if !slices.Contains(schemaAnnotations, key) {
+				return nil, fmt.Errorf("unsupported keyword %q at %q", key, schemaPath(at))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func schemaPath(at string) string {
+	if at == "" {
+		return "/"
+	}
+	return at
+}
+
+// validate returns an error naming the location of the first violation of
+// the schema in the JSON payload.
+func (s *recordSchema) validate(payload []byte) error {
+	v, err := decodeJSON(payload)
+	if err != nil {
+		return fmt.Errorf("at /: payload is not valid JSON: %w", err)
+	}
+	return s.check(v, "")
+}
+
+func (s *recordSchema) check(v any, at string) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("at %s: %s", schemaPath(at), fmt.Sprintf(format, args...))
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			return fail("no value is allowed")
+		}
+		return nil
+	}
+	if s.ref != "" {
+		target := s.root
+		if s.ref != "#" {
+			target = s.root.defs[s.ref]
+		}
+		if err := target.check(v, at); err != nil {
+			return err
+		}
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return jsonTypeMatches(t, v) }) {
+		return fail("expected %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(v))
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return jsonEqual(e, v) }) {
+		return fail("value is not one of the allowed values")
+	}
+	if s.hasConst && !jsonEqual(s.constValue, v) {
+		return fail("value does not equal the required constant")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			child := at + "/" + name
+			if p, ok := s.properties[name]; ok {
+				if err := p.check(v[name], child); err != nil {
+					return err
+				}
+			} else if s.additionalProperties != nil {
+				if err := s.additionalProperties.check(v[name], child); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fail("expected at least %d items, got %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fail("expected at most %d items, got %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, e := range v {
+				if err := s.items.check(e, fmt.Sprintf("%s/%d", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return fail("expected at least %d characters, got %d", *s.minLength, n)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fail("expected at most %d characters, got %d", *s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fail("value does not match pattern %q", s.pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		switch {
+		case s.minimum != nil && f < *s.minimum:
+			return fail("%s is less than the minimum %v", v, *s.minimum)
+		case s.maximum != nil && f > *s.maximum:
+			return fail("%s is greater than the maximum %v", v, *s.maximum)
+		case s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum:
+			return fail("%s is not greater than %v", v, *s.exclusiveMinimum)
+		case s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum:
+			return fail("%s is not less than %v", v, *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.check(v, at); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil && !slices.ContainsFunc(s.anyOf, func(sub *recordSchema) bool { return sub.check(v, at) == nil }) {
+		return fail("value matches none of anyOf")
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.check(v, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("value matches %d of oneOf, expected exactly 1", matched)
+		}
+	}
+	if s.not != nil && s.not.check(v, at) == nil {
+		return fail("value matches the schema in not")
+	}
+	return nil
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case json.Number:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func jsonTypeMatches(t string, v any) bool {
+	if t == "integer" {
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	}
+	return t == jsonTypeOf(v)
+}
+
+// jsonEqual compares decoded JSON values, numbers by value.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, _ := a.Float64()
+		bf, _ := bn.Float64()
+		return af == bf
+	case map[string]any:
+		bm, ok := b.(map[string]any)
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := bm[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bl, ok := b.([]any)
+		return ok && slices.EqualFunc(a, bl, jsonEqual)
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRedactPayload(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules []RedactRule
+		in    string
+		want  string
+	}{
+		{
+			name:  "drop nested",
+			rules: []RedactRule{{Path: "user.ssn"}},
+			in:    `{"user":{"name":"alice","ssn":"123-45-6789"}}`,
+			want:  `{"user":{"name":"alice"}}`,
+		},
+		{
+			name:  "mask with default",
+			rules: []RedactRule{{Path: "user.ssn", Strategy: "mask"}},
+			in:    `{"user":{"ssn":"123-45-6789"}}`,
+			want:  `{"user":{"ssn":"REDACTED"}}`,
+		},
+		{
+			name:  "mask with fixed string",
+			rules: []RedactRule{{Path: "password", Strategy: "mask", Mask: "***"}},
+			in:    `{"password":"hunter2","id":7}`,
+			want:  `{"id":7,"password":"***"}`,
+		},
+		{
+			name:  "hash string",
+			rules: []RedactRule{{Path: "email", Strategy: "hash"}},
+			in:    `{"email":"alice@example.com"}`,
+			want:  `{"email":"` + sha256Hex([]byte("alice@example.com")) + `"}`,
+		},
+		{
+			name:  "hash non-string by its JSON",
+			rules: []RedactRule{{Path: "account", Strategy: "hash"}},
+			in:    `{"account":{"id":12345678901234567890}}`,
+			want:  `{"account":"` + sha256Hex([]byte(`{"id":12345678901234567890}`)) + `"}`,
+		},
+		{
+			name:  "every array element",
+			rules: []RedactRule{{Path: "items.card", Strategy: "mask"}},
+			in:    `{"items":[{"card":"4111","sku":"a"},{"sku":"b"},{"card":"5500","sku":"c"}]}`,
+			want:  `{"items":[{"card":"REDACTED","sku":"a"},{"sku":"b"},{"card":"REDACTED","sku":"c"}]}`,
+		},
+		{
+			name:  "nested arrays",
+			rules: []RedactRule{{Path: "orders.lines.card"}},
+			in:    `{"orders":[{"lines":[{"card":"4111","qty":1}]},{"lines":[[{"card":"5500"}]]}]}`,
+			want:  `{"orders":[{"lines":[{"qty":1}]},{"lines":[[{}]]}]}`,
+		},
+		{
+			name:  "top-level array",
+			rules: []RedactRule{{Path: "token"}},
+			in:    `[{"token":"a","id":1},{"token":"b","id":2}]`,
+			want:  `[{"id":1},{"id":2}]`,
+		},
+		{
+			name:  "several rules",
+			rules: []RedactRule{{Path: "user.ssn"}, {Path: "user.email", Strategy: "hash"}, {Path: "note", Strategy: "mask", Mask: "-"}},
+			in:    `{"user":{"ssn":"1","email":"e"},"note":"n"}`,
+			want:  `{"note":"-","user":{"email":"` + sha256Hex([]byte("e")) + `"}}`,
+		},
+		{
+			name:  "missing path leaves the payload untouched",
+			rules: []RedactRule{{Path: "user.ssn"}},
+			in:    `{"user": "alice",  "n": 1.50}`,
+			want:  `{"user": "alice",  "n": 1.50}`,
+		},
+		{
+			name:  "numbers keep their precision",
+			rules: []RedactRule{{Path: "secret"}},
+			in:    `{"secret":1,"big":12345678901234567890,"f":1.50}`,
+			want:  `{"big":12345678901234567890,"f":1.50}`,
+		},
+		{
+			name:  "html is not escaped",
+			rules: []RedactRule{{Path: "secret"}},
+			in:    `{"secret":1,"html":"<b>&</b>"}`,
+			want:  `{"html":"<b>&</b>"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newRedactor(tc.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.redactPayload([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("redactPayload(%s) = %s, want %s", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactPayloadMalformedDoesNotLeak(t *testing.T) {
+	const secret = "123-45-6789"
+	r, err := newRedactor([]RedactRule{{Path: "ssn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{
+		`{"ssn":"` + secret + `"`,
+		`{"ssn":` + secret + `}`,
+		`{"ssn" "` + secret + `"}`,
+		`{"id":1} {"ssn":"` + secret + `"}`,
+		`{"id":1}` + secret,
+		secret,
+	} {
+		out, err := r.redactPayload([]byte(in))
+		if err == nil {
+			t.Errorf("redactPayload(%s) = %s, want an error", in, out)
+			continue
+		}
+		for _, leak := range []string{secret, "123", "'1'", "'-'"} {
+			if strings.Contains(err.Error(), leak) {
+				t.Errorf("redactPayload(%s) error %q leaks %q from the payload", in, err, leak)
+			}
+		}
+	}
+}
+
+func TestNewRedactorValidation(t *testing.T) {
+	for _, tc := range []struct {
+		rule RedactRule
+		err  string
+	}{
+		{RedactRule{Path: "a..b"}, `redact[0]: invalid path "a..b"`},
+		{RedactRule{Path: ""}, `redact[0]: invalid path ""`},
+		{RedactRule{Path: "a", Strategy: "encrypt"}, `unsupported strategy "encrypt"`},
+	} {
+		_, err := newRedactor([]RedactRule{tc.rule})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("newRedactor(%+v): error %v, want it to contain %q", tc.rule, err, tc.err)
+		}
+	}
+}
+
+// captureServer records the bodies it receives.
+type captureServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newCaptureServer(t *testing.T) *captureServer {
+	t.Helper()
+	cs := &captureServer{}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		cs.mu.Lock()
+		cs.bodies = append(cs.bodies, string(body))
+		cs.mu.Unlock()
+	}))
+	t.Cleanup(cs.Close)
+	return cs
+}
+
+func (cs *captureServer) received() []string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return append([]string(nil), cs.bodies...)
+}
+
+func TestRedactAcrossBatchFormats(t *testing.T) {
+	const (
+		ssn   = "123-45-6789"
+		email = "alice@example.com"
+		card1 = "4111111111111111"
+		card2 = "5500005555555559"
+	)
+	record := fmt.Sprintf(`{"name":"alice","user":{"ssn":%q,"email":%q},"items":[{"card":%q},{"card":%q}]}`, ssn, email, card1, card2)
+	redact := `[
+		{"path": "user.ssn"},
+		{"path": "user.email", "strategy": "mask", "mask": "***"},
+		{"path": "items.card", "strategy": "hash"}
+	]`
+
+	for _, format := range []string{"json_array", "ndjson", "xml", "msgpack", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			srv := newCaptureServer(t)
+			s, state := newTestSession(t, fmt.Sprintf(`{
+				"endpoint": %q,
+				"batch_format": %q,
+				"csv_columns": ["name", "user", "items"],
+				"redact": %s
+			}`, srv.URL, format, redact))
+
+			if _, err := send(context.Background(), s, state, testBatch(record, record)); err != nil {
+				t.Fatal(err)
+			}
+			bodies := srv.received()
+			if len(bodies) != 1 {
+				t.Fatalf("server received %d requests, want 1", len(bodies))
+			}
+			body := bodies[0]
+			for _, leak := range []string{ssn, "ssn", email, card1, card2} {
+				if strings.Contains(body, leak) {
+					t.Errorf("%s body leaks %q: %q", format, leak, body)
+				}
+			}
+			for _, want := range []string{"alice", "***", sha256Hex([]byte(card1)), sha256Hex([]byte(card2))} {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s body lacks %q: %q", format, want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactMalformedRecordFailsAlone(t *testing.T) {
+	const secret = "123-45-6789"
+	srv := newCaptureServer(t)
+	s, state := newTestSession(t, fmt.Sprintf(`{"endpoint": %q, "redact": [{"path": "ssn"}]}`, srv.URL))
+
+	_, err := send(context.Background(), s, state, testBatch(`{"id":1,"ssn":"1"}`, `{"id":2,"ssn":"`+secret, `{"id":3}`))
+	var perr *partialError
+	if !errors.As(err, &perr) {
+		t.Fatalf("err = %v, want a *partialError", err)
+	}
+	if len(perr.failed) != 1 || perr.failed[0] != 1 {
+		t.Errorf("failed records = %v, want [1]", perr.failed)
+	}
+	if strings.Contains(err.Error(), secret) {
+		t.Errorf("error %q leaks the malformed payload", err)
+	}
+
+	bodies := srv.received()
+	if len(bodies) != 1 {
+		t.Fatalf("server received %d requests, want 1", len(bodies))
+	}
+	if want := `[{"id":1},{"id":3}]`; bodies[0] != want {
+		t.Errorf("body = %s, want %s", bodies[0], want)
+	}
+}
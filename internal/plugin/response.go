@@ -0,0 +1,354 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/planx-lab/planx-common/logger"
+	planxv1 "github.com/planx-lab/planx-proto/gen/go/planx/v1"
+)
+
+const (
+	defaultMaxResponseBytes = 4096
+	defaultAccept           = "application/json"
+
+	// maxDrainBytes bounds how much of an unread response body is discarded
+	// to keep the connection reusable; larger bodies close the connection.
+	maxDrainBytes = 256 << 10
+
+	// maxExtractBytes bounds the response body parsed for ResponseExtract.
+	maxExtractBytes = 1 << 20
+)
+
+// capturedResponse is a successful response reported back in the ack.
+type capturedResponse struct {
+	StatusCode int    `json:"status_code"`
+	Body       string `json:"body"`
+	Truncated  bool   `json:"truncated,omitempty"`
+}
+
+// delivery collects what a batch's requests report back to the pipeline.
+// Requests of one batch may complete concurrently, so it is guarded by mu.
+type delivery struct {
+	mu         sync.Mutex
+	Responses  []capturedResponse `json:"responses,omitempty"`
+	Extracted  map[int]any        `json:"extracted,omitempty"`  // ResponseExtract values by record index
+	Throttled  bool               `json:"throttled,omitempty"`  // see Config.BackpressureMode
+	Duplicates int                `json:"duplicates,omitempty"` // records dropped by Config.Dedup
+	attempts   int                // most attempts made by any of the batch's requests
+	urls       []string           // distinct URLs the batch's requests were sent to, in first-use order
+}
+
+// noteAttempts records that a request of the batch took n attempts.
+func (d *delivery) noteAttempts(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attempts = max(d.attempts, n)
+}
+
+// noteURL records that a request of the batch was sent to u.
+func (d *delivery) noteURL(u string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !slices.Contains(d.urls, u) {
+		d.urls = append(d.urls, u)
+	}
+}
+
+// sentTo returns the redacted URL the batch was sent to and, when its
+// requests went to several, all of them. A batch that failed before any
+// request was sent reports the session's endpoint.
+func (d *delivery) sentTo(state *sessionState) (endpoint string, endpoints []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch len(d.urls) {
+	case 0:
+		return redactURL(state.cfg.Endpoint), nil
+	case 1:
+		return redactURL(d.urls[0]), nil
+	}
+	endpoints = make([]string, len(d.urls))
+	for i, u := range d.urls {
+		endpoints[i] = redactURL(u)
+	}
+	return "", endpoints
+}
+
+// noteDuplicates records that n records of the batch were dropped as
+// duplicates.
+func (d *delivery) noteDuplicates(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Duplicates += n
+}
+
+// throttle marks the delivery throttled when the session signals
+// backpressure and the batch waited for capacity or needed retries.
+func (d *delivery) throttle(state *sessionState, waited bool) {
+	if state.cfg.BackpressureMode != "signal" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if waited || d.attempts > 1 {
+		d.Throttled = true
+	}
+}
+
+// attemptCount returns the most attempts made by any of the batch's requests.
+func (d *delivery) attemptCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attempts
+}
+
+// record captures and extracts from a successful response to a request
+// carrying the records at indices.
+func (d *delivery) record(state *sessionState, resp *http.Response, indices []int) {
+	limit := state.cfg.MaxResponseBytes
+	if state.extractPath != nil {
+		limit = max(limit, maxExtractBytes)
+	}
+	body, truncated := readBody(resp.Body, limit)
+
+	var extracted any
+	if state.extractPath != nil {
+		var err error
+		if truncated {
+			err = fmt.Errorf("response larger than %d bytes", maxExtractBytes)
+		} else {
+			extracted, err = extractValue(resp.Header.Get("Content-Type"), body, state.extractPath)
+		}
+		if err != nil {
+			logger.Warn().Err(err).Str("session_id", state.id).Msg("Failed to extract from response")
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state.cfg.CaptureResponse {
+		c := capturedResponse{StatusCode: resp.StatusCode, Body: string(body)}
+		if len(body) > state.cfg.MaxResponseBytes {
+			c.Body, truncated = string(body[:state.cfg.MaxResponseBytes]), true
+		}
+		c.Truncated = truncated
+		d.Responses = append(d.Responses, c)
+	}
+
+	if extracted == nil {
+		return
+	}
+	if d.Extracted == nil {
+		d.Extracted = make(map[int]any)
+	}
+	// An array with one element per record maps onto the records in order;
+	// any other value, e.g. a single receipt, applies to every record.
+	if values, ok := extracted.([]any); ok && len(values) == len(indices) && len(indices) > 1 {
+		for i, idx := range indices {
+			d.Extracted[idx] = values[i]
+		}
+		return
+	}
+	for _, idx := range indices {
+		d.Extracted[idx] = extracted
+	}
+}
+
+// merge adds what sub collected, mapping its record indices through kept.
+func (d *delivery) merge(sub *delivery, kept []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Responses = append(d.Responses, sub.Responses...)
+	d.attempts = max(d.attempts, sub.attempts)
+	for _, u := range sub.urls {
+		if !slices.Contains(d.urls, u) {
+			d.urls = append(d.urls, u)
+		}
+	}
+	for i, v := range sub.Extracted {
+		if d.Extracted == nil {
+			d.Extracted = make(map[int]any)
+		}
+		d.Extracted[kept[i]] = v
+	}
+}
+
+// slice returns the delivery of the n records at offset, renumbered from 0.
+// Captured responses are not attributed to records and are all kept.
+func (d *delivery) slice(offset, n int) *delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := &delivery{Responses: d.Responses, Throttled: d.Throttled}
+	for i, v := range d.Extracted {
+		if i >= offset && i < offset+n {
+			if out.Extracted == nil {
+				out.Extracted = make(map[int]any)
+			}
+			out.Extracted[i-offset] = v
+		}
+	}
+	return out
+}
+
+// validateAckDetail checks that settings reporting in the ack detail are
+// only used with AckDetail.
+func validateAckDetail(cfg Config) error {
+	if cfg.AckDetail {
+		return nil
+	}
+	switch {
+	case cfg.CaptureResponse:
+		return fmt.Errorf("capture_response requires ack_detail")
+	case cfg.ResponseExtract != "":
+		return fmt.Errorf("response_extract requires ack_detail")
+	case cfg.BackpressureMode == "signal":
+		return fmt.Errorf("backpressure_mode signal requires ack_detail")
+	}
+	return nil
+}
+
+// ack builds the acknowledgement for a successfully delivered batch.
+// AckResponse has no structured detail field, so any collected detail is
+// JSON-encoded into Error; Success remains authoritative. Detail is only
+// collected for sessions with AckDetail.
+func (d *delivery) ack() *planxv1.AckResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ack := &planxv1.AckResponse{Success: true}
+	if len(d.Responses) == 0 && len(d.Extracted) == 0 && !d.Throttled && d.Duplicates == 0 {
+		return ack
+	}
+	if detail, err := json.Marshal(d); err == nil {
+		ack.Error = string(detail)
+	}
+	return ack
+}
+
+// readBody reads up to limit bytes of body and reports whether more remained.
+func readBody(body io.Reader, limit int) ([]byte, bool) {
+	b, _ := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if len(b) > limit {
+		return b[:limit], true
+	}
+	return b, false
+}
+
+// peekBody reads up to limit bytes of resp.Body like readBody, then puts
+// them back in front of the unread remainder so the body can be read again.
+func peekBody(resp *http.Response, limit int) ([]byte, bool) {
+	body, truncated := readBody(resp.Body, limit)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	return body, truncated
+}
+
+// drainBody discards the unread remainder of body and closes it.
+func drainBody(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+// parseExtractPath splits a ResponseExtract path such as "$.data.ids" or
+// "id" into its segments, returning nil when none is configured. "$" alone
+// selects the whole body.
+func parseExtractPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return []string{}, nil
+	}
+	segs := strings.Split(path, ".")
+	for _, seg := range segs {
+		if seg == "" {
+			return nil, fmt.Errorf("invalid response_extract path %q", path)
+		}
+	}
+	return segs, nil
+}
+
+// extractValue returns the value at path in the JSON or XML body, per
+// contentType; bodies without a Content-Type are parsed as JSON. Arrays along
+// the path are traversed element by element, so "items.id" of
+// {"items":[{"id":1},{"id":2}]} yields [1, 2].
+func extractValue(contentType string, body []byte, path []string) (any, error) {
+	var v any
+	switch responseFormat(contentType) {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("response is not valid JSON: %w", err)
+		}
+	case "xml":
+		var err error
+		if v, err = decodeXML(body); err != nil {
+			return nil, fmt.Errorf("response is not valid XML: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot extract from response with Content-Type %q: expected JSON or XML", contentType)
+	}
+
+	v, ok := lookupPath(v, path)
+	if !ok {
+		return nil, fmt.Errorf("response has no value at %q", strings.Join(path, "."))
+	}
+	return v, nil
+}
+
+// responseFormat classifies a response Content-Type as "json", "xml" or "".
+func responseFormat(contentType string) string {
+	if contentType == "" {
+		return "json"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return "xml"
+	default:
+		return ""
+	}
+}
+
+func lookupPath(v any, path []string) (any, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+	switch v := v.(type) {
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, e := range v {
+			if ev, ok := lookupPath(e, path); ok {
+				out = append(out, ev)
+			}
+		}
+		return out, true
+	case map[string]any:
+		field, ok := v[path[0]]
+		if !ok {
+			return nil, false
+		}
+		return lookupPath(field, path[1:])
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryConfig controls how failed sends are retried.
+type RetryConfig struct {
+	MaxAttempts    int     `json:"max_attempts"`    // total attempts including the first; <= 1 disables retries
+	InitialBackoff string  `json:"initial_backoff"` // e.g., "200ms"
+	MaxBackoff     string  `json:"max_backoff"`     // e.g., "30s"
+	Multiplier     float64 `json:"multiplier"`      // backoff growth factor per attempt
+	JitterStrategy string  `json:"jitter_strategy"` // none, full (default), equal, decorrelated
+
+	Budget RetryBudgetConfig `json:"budget"`
+}
+
+const (
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMultiplier     = 2.0
+)
+
+// retryPolicy is the parsed form of RetryConfig.
+type retryPolicy struct {
+	maxAttempts int
+	initial     time.Duration
+	max         time.Duration
+	multiplier  float64
+	jitter      jitterFunc
+	rand        *rand.Rand   // nil: the global source; set to seed the jitter in tests
+	budget      *retryBudget // nil unless the retry budget is enabled
+}
+
+func newRetryPolicy(cfg RetryConfig) (retryPolicy, error) {
+	p := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		initial:     defaultInitialBackoff,
+		max:         defaultMaxBackoff,
+		multiplier:  cfg.Multiplier,
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	if p.multiplier == 0 {
+		p.multiplier = defaultMultiplier
+	}
+	if p.multiplier < 1 {
+		return retryPolicy{}, fmt.Errorf("retry.multiplier must be >= 1, got %v", cfg.Multiplier)
+	}
+
+	if cfg.InitialBackoff != "" {
+		d, err := time.ParseDuration(cfg.InitialBackoff)
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid retry.initial_backoff: %w", err)
+		}
+		p.initial = d
+	}
+	if cfg.MaxBackoff != "" {
+		d, err := time.ParseDuration(cfg.MaxBackoff)
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid retry.max_backoff: %w", err)
+		}
+		p.max = d
+	}
+	if p.initial > p.max {
+		p.initial = p.max
+	}
+
+	jitter, ok := jitterStrategies[cmp.Or(cfg.JitterStrategy, "full")]
+	if !ok {
+		return retryPolicy{}, fmt.Errorf("unsupported retry.jitter_strategy %q: must be one of none, full, equal, decorrelated", cfg.JitterStrategy)
+	}
+	p.jitter = jitter
+
+	budget, err := newRetryBudget(cfg.Budget)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	p.budget = budget
+	return p, nil
+}
+
+// backoff returns the delay to wait after the given (1-based) failed attempt,
+// prev being the delay before the previous retry, if any. The exponential
+// delay is capped at max and then jittered so sessions failing together do
+// not retry in lockstep.
+func (p retryPolicy) backoff(attempt int, prev time.Duration) time.Duration {
+	d := float64(p.initial)
+	for i := 1; i < attempt && d < float64(p.max); i++ {
+		d *= p.multiplier
+	}
+	if d > float64(p.max) {
+		d = float64(p.max)
+	}
+	return p.jitter(p, time.Duration(d), prev)
+}
+
+// delay returns how long to wait before retrying after err. A server-supplied
+// Retry-After takes precedence over the backoff curve but is still capped at max.
+func (p retryPolicy) delay(attempt int, prev time.Duration, err error) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return min(se.RetryAfter, p.max)
+	}
+	return p.backoff(attempt, prev)
+}
+
+// jitterFunc randomizes the capped exponential delay d. prev is the previous
+// delay, zero before the first retry.
+type jitterFunc func(p retryPolicy, d, prev time.Duration) time.Duration
+
+var jitterStrategies = map[string]jitterFunc{
+	"none":         noJitter,
+	"full":         fullJitter,
+	"equal":        equalJitter,
+	"decorrelated": decorrelatedJitter,
+}
+
+// noJitter returns d unchanged.
+func noJitter(_ retryPolicy, d, _ time.Duration) time.Duration {
+	return d
+}
+
+// fullJitter returns a delay in [0, d].
+func fullJitter(p retryPolicy, d, _ time.Duration) time.Duration {
+	return p.randN(d + 1)
+}
+
+// equalJitter returns a delay in [d/2, d].
+func equalJitter(p retryPolicy, d, _ time.Duration) time.Duration {
+	half := d / 2
+	return half + p.randN(d-half+1)
+}
+
+// decorrelatedJitter returns a delay in [initial, 3*prev], capped at max,
+// independent of the attempt number. Growing from the previous delay rather
+// than the attempt spreads out clients that started failing together.
+func decorrelatedJitter(p retryPolicy, _, prev time.Duration) time.Duration {
+	prev = max(prev, p.initial)
+	upper := min(3*prev, p.max)
+	if upper <= p.initial {
+		return upper
+	}
+	return p.initial + p.randN(upper-p.initial+1)
+}
+
+// randN returns a random duration in [0, n).
+func (p retryPolicy) randN(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	if p.rand != nil {
+		return time.Duration(p.rand.Int64N(int64(n)))
+	}
+	return rand.N(n)
+}
+
+// isRetriable reports whether a failed attempt may be retried. Network errors
+// and responses the status policy marks retriable are; cancellation is not.
+func isRetriable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.Retriable
+	}
+	// client.Do reports transport failures as *url.Error.
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// isIdempotent reports whether repeating a request with method has the same
+// effect as sending it once (RFC 9110, section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// maybeProcessed reports whether a failed request may have reached the
+// endpoint: it failed without a response after a connection was made.
+func maybeProcessed(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) || errors.Is(err, errConnWait) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	var opErr *net.OpError
+	return !errors.As(err, &opErr) || opErr.Op != "dial"
+}
+
+// isConnReset reports whether err is a connection the endpoint reset or
+// closed mid-request, as load balancers do when recycling connections, or
+// one that stalled past io_timeout. An EOF only counts when the transport
+// reports it: readers and decoders return one for a body that merely ends.
+func isConnReset(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, errConnStalled) {
+		return true
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return isEOF(urlErr.Err)
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && isEOF(opErr.Err)
+}
+
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value given either as delay
+// seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsConnReset(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"url.Error EOF", &url.Error{Op: "Put", URL: "http://x", Err: io.EOF}, true},
+		{"wrapped url.Error EOF", fmt.Errorf("HTTP request failed: %w", &url.Error{Op: "Put", URL: "http://x", Err: io.EOF}), true},
+		{"url.Error unexpected EOF", &url.Error{Op: "Put", URL: "http://x", Err: io.ErrUnexpectedEOF}, true},
+		{"read EOF", &net.OpError{Op: "read", Net: "tcp", Err: io.EOF}, true},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"broken pipe", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"stalled", fmt.Errorf("HTTP request failed: %w", errConnStalled), true},
+		{"bare EOF", io.EOF, false},
+		{"decoder EOF", fmt.Errorf("failed to parse response: %w", io.ErrUnexpectedEOF), false},
+		{"url.Error timeout", &url.Error{Op: "Put", URL: "http://x", Err: context.DeadlineExceeded}, false},
+		{"status", &statusError{StatusCode: 503}, false},
+	} {
+		if got := isConnReset(tc.err); got != tc.want {
+			t.Errorf("isConnReset(%s: %v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestSendRetriesClosedConnectionAtOnce(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			// Close the connection once the request headers are in,
+			// without a response, as a recycling load balancer does.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{
+		"endpoint": %q,
+		"method": "PUT",
+		"retry": {"max_attempts": 3, "initial_backoff": "10s", "jitter_strategy": "none"}
+	}`, srv.URL))
+	start := time.Now()
+	d, err := send(context.Background(), s, state, testBatch(`{"id":1}`))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("server saw %d attempts, want 2", n)
+	}
+	if n := d.attemptCount(); n != 2 {
+		t.Errorf("delivery recorded %d attempts, want 2", n)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retry of a closed connection took %v; want it without the 10s backoff", elapsed)
+	}
+}
+
+func TestSendBacksOffAfterSecondClosedConnection(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{
+		"endpoint": %q,
+		"method": "PUT",
+		"retry": {"max_attempts": 3, "initial_backoff": "10s", "jitter_strategy": "none"}
+	}`, srv.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := send(ctx, s, state, testBatch(`{"id":1}`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the backoff to run into the deadline", err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("server saw %d attempts, want 2 before the backoff", n)
+	}
+}
+
+// expBackoff returns the capped exponential delay after attempt.
+func expBackoff(p retryPolicy, attempt int) time.Duration {
+	d := p.initial
+	for range attempt - 1 {
+		d = time.Duration(float64(d) * p.multiplier)
+		if d >= p.max {
+			return p.max
+		}
+	}
+	return min(d, p.max)
+}
+
+func TestJitterBounds(t *testing.T) {
+	const samples = 2000
+	for _, tc := range []struct {
+		strategy string
+		// bounds returns the range a delay must fall in given the capped
+		// exponential delay d and the previous delay.
+		bounds func(p retryPolicy, d, prev time.Duration) (lo, hi time.Duration)
+	}{
+		{"none", func(_ retryPolicy, d, _ time.Duration) (time.Duration, time.Duration) { return d, d }},
+		{"full", func(_ retryPolicy, d, _ time.Duration) (time.Duration, time.Duration) { return 0, d }},
+		{"equal", func(_ retryPolicy, d, _ time.Duration) (time.Duration, time.Duration) { return d / 2, d }},
+		{"decorrelated", func(p retryPolicy, _, prev time.Duration) (time.Duration, time.Duration) {
+			return p.initial, min(3*max(prev, p.initial), p.max)
+		}},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			p, err := newRetryPolicy(RetryConfig{
+				MaxAttempts:    10,
+				InitialBackoff: "100ms",
+				MaxBackoff:     "5s",
+				JitterStrategy: tc.strategy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.rand = rand.New(rand.NewPCG(1, 2))
+
+			for attempt := 1; attempt <= 8; attempt++ {
+				d := expBackoff(p, attempt)
+				var prevs []time.Duration
+				if tc.strategy == "decorrelated" {
+					prevs = []time.Duration{0, p.initial, 700 * time.Millisecond, p.max}
+				} else {
+					prevs = []time.Duration{0}
+				}
+				for _, prev := range prevs {
+					lo, hi := tc.bounds(p, d, prev)
+					seenLo, seenHi := hi, lo
+					for range samples {
+						got := p.backoff(attempt, prev)
+						if got < lo || got > hi {
+							t.Fatalf("backoff(%d, %v) = %v, want within [%v, %v]", attempt, prev, got, lo, hi)
+						}
+						seenLo, seenHi = min(seenLo, got), max(seenHi, got)
+					}
+					// The delays must spread over the range, not sit at one end.
+					if slack := (hi - lo) / 10; seenLo > lo+slack || seenHi < hi-slack {
+						t.Errorf("backoff(%d, %v) spanned [%v, %v] over %d samples, want close to [%v, %v]",
+							attempt, prev, seenLo, seenHi, samples, lo, hi)
+					}
+				}
+			}
+		})
+	}
+}
+
+// seededBackoffs returns the first eight delays of a policy with the given
+// jitter strategy, seeded so runs are repeatable.
+func seededBackoffs(t *testing.T, strategy string) []time.Duration {
+	t.Helper()
+	p, err := newRetryPolicy(RetryConfig{MaxAttempts: 10, JitterStrategy: strategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rand = rand.New(rand.NewPCG(42, 7))
+	var out []time.Duration
+	var prev time.Duration
+	for attempt := 1; attempt <= 8; attempt++ {
+		prev = p.backoff(attempt, prev)
+		out = append(out, prev)
+	}
+	return out
+}
+
+func TestJitterSeeded(t *testing.T) {
+	for strategy := range jitterStrategies {
+		if a, b := seededBackoffs(t, strategy), seededBackoffs(t, strategy); !slices.Equal(a, b) {
+			t.Errorf("%s: seeded sequences differ: %v vs %v", strategy, a, b)
+		}
+	}
+	if a, b := seededBackoffs(t, ""), seededBackoffs(t, "full"); !slices.Equal(a, b) {
+		t.Errorf("default jitter gave %v, want full jitter's %v", a, b)
+	}
+}
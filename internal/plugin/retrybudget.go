@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// RetryBudgetConfig caps the retries of a session to Ratio of its requests
+// over the sliding Window, so an outage does not multiply the load on a
+// struggling endpoint. MinRetries per window are always allowed, letting
+// sessions with little traffic retry. Once the budget is spent failed
+// requests are not retried until it recovers.
+type RetryBudgetConfig struct {
+	Ratio      float64 `json:"ratio"`       // e.g. 0.1; 0 disables
+	Window     string  `json:"window"`      // default 10s
+	MinRetries int     `json:"min_retries"` // default 10
+}
+
+const (
+	defaultBudgetWindow     = 10 * time.Second
+	defaultBudgetMinRetries = 10
+
+	// budgetBuckets is the resolution of the sliding window.
+	budgetBuckets = 10
+)
+
+var errRetryBudget = errors.New("retry budget exhausted")
+
+// retryBudget counts a session's requests and retries over a sliding
+// window of buckets.
+type retryBudget struct {
+	ratio      float64
+	minRetries int
+	bucket     time.Duration
+
+	mu        sync.Mutex
+	requests  [budgetBuckets]int
+	retries   [budgetBuckets]int
+	current   int       // bucket counted into
+	startedAt time.Time // of the current bucket
+	exhausted bool      // as of the last retry refused
+}
+
+// newRetryBudget validates cfg, returning nil when it is disabled.
+func newRetryBudget(cfg RetryBudgetConfig) (*retryBudget, error) {
+	if cfg.Ratio == 0 {
+		if cfg != (RetryBudgetConfig{}) {
+			return nil, fmt.Errorf("retry.budget settings require retry.budget.ratio")
+		}
+		return nil, nil
+	}
+	if cfg.Ratio < 0 {
+		return nil, fmt.Errorf("retry.budget.ratio must be > 0")
+	}
+	if cfg.MinRetries < 0 {
+		return nil, fmt.Errorf("retry.budget.min_retries must be >= 0")
+	}
+
+	window := defaultBudgetWindow
+	if cfg.Window != "" {
+		d, err := time.ParseDuration(cfg.Window)
+		if err != nil || d < budgetBuckets*time.Millisecond {
+			return nil, fmt.Errorf("invalid retry.budget.window %q", cfg.Window)
+		}
+		window = d
+	}
+	b := &retryBudget{
+		ratio:      cfg.Ratio,
+		minRetries: defaultBudgetMinRetries,
+		bucket:     window / budgetBuckets,
+		startedAt:  time.Now(),
+	}
+	if cfg.MinRetries > 0 {
+		b.minRetries = cfg.MinRetries
+	}
+	return b, nil
+}
+
+// advance moves the window up to now. Callers must hold mu.
+func (b *retryBudget) advance(now time.Time) {
+	for n := 0; now.Sub(b.startedAt) >= b.bucket && n < budgetBuckets; n++ {
+		b.current = (b.current + 1) % budgetBuckets
+		b.requests[b.current], b.retries[b.current] = 0, 0
+		b.startedAt = b.startedAt.Add(b.bucket)
+	}
+	if now.Sub(b.startedAt) >= b.bucket {
+		// Idle for the whole window; every bucket was cleared.
+		b.startedAt = now
+	}
+}
+
+// remaining returns the retries left in the window. Callers must hold mu.
+func (b *retryBudget) remaining() int {
+	requests, retries := 0, 0
+	for i := range budgetBuckets {
+		requests += b.requests[i]
+		retries += b.retries[i]
+	}
+	return max(b.minRetries, int(b.ratio*float64(requests))) - retries
+}
+
+// request counts a request sent for the first time.
+func (b *retryBudget) request(state *sessionState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.requests[b.current]++
+	retryBudgetRemaining.WithLabelValues(state.tenantID, state.id).Set(float64(b.remaining()))
+}
+
+// retry reports whether a retry fits the budget, counting it if so.
+func (b *retryBudget) retry(state *sessionState) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	left := b.remaining()
+	if left <= 0 {
+		retryBudgetExhausted.WithLabelValues(state.tenantID).Inc()
+		if !b.exhausted {
+			b.exhausted = true
+			logger.Warn().
+				Str("session_id", state.id).
+				Float64("ratio", b.ratio).
+				Dur("window", b.bucket*budgetBuckets).
+				Msg("Retry budget exhausted; failing requests without retrying")
+		}
+		return false
+	}
+	if b.exhausted {
+		b.exhausted = false
+		logger.Info().Str("session_id", state.id).Msg("Retry budget recovered; retrying again")
+	}
+	b.retries[b.current]++
+	retryBudgetRemaining.WithLabelValues(state.tenantID, state.id).Set(float64(left - 1))
+	return true
+}
+
+// forget removes the session's budget metric.
+func (b *retryBudget) forget(tenantID, sessionID string) {
+	retryBudgetRemaining.DeleteLabelValues(tenantID, sessionID)
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// RetryResponseMatchConfig retries successful responses whose body signals
+// a transient failure, e.g. {"status":"throttled"}. Either the value at Path
+// in a JSON or XML body is one of Values, or Pattern, a regular expression,
+// matches the raw body.
+type RetryResponseMatchConfig struct {
+	Path    string   `json:"path"`
+	Values  []string `json:"values"`
+	Pattern string   `json:"pattern"`
+}
+
+// retryMatcher is the parsed form of RetryResponseMatchConfig.
+type retryMatcher struct {
+	path    []string
+	values  []string
+	pattern *regexp.Regexp
+}
+
+// newRetryMatcher returns nil when no match is configured.
+func newRetryMatcher(cfg RetryResponseMatchConfig) (*retryMatcher, error) {
+	switch {
+	case cfg.Path == "" && len(cfg.Values) == 0 && cfg.Pattern == "":
+		return nil, nil
+	case cfg.Pattern != "" && (cfg.Path != "" || len(cfg.Values) > 0):
+		return nil, fmt.Errorf("retry_response_match: set either path and values or pattern, not both")
+	case cfg.Pattern != "":
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_response_match.pattern: %w", err)
+		}
+		return &retryMatcher{pattern: re}, nil
+	case cfg.Path == "" || len(cfg.Values) == 0:
+		return nil, fmt.Errorf("retry_response_match.path and retry_response_match.values must be set together")
+	}
+
+	path, err := parseExtractPath(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry_response_match.path: %w", err)
+	}
+	return &retryMatcher{path: path, values: cfg.Values}, nil
+}
+
+// check returns a retriable *statusError when the successful response's
+// body matches. The inspected part of the body is put back in front of
+// resp.Body so it can still be captured.
+func (m *retryMatcher) check(state *sessionState, resp *http.Response) error {
+	body, truncated := peekBody(resp, maxExtractBytes)
+	if truncated && m.pattern == nil {
+		logger.Warn().
+			Str("session_id", state.id).
+			Int("limit", maxExtractBytes).
+			Msg("Response too large to check retry_response_match")
+		return nil
+	}
+	if !m.matches(resp.Header.Get("Content-Type"), body) {
+		return nil
+	}
+
+	if len(body) > state.cfg.MaxResponseBytes {
+		body = body[:state.cfg.MaxResponseBytes]
+	}
+	se := &statusError{StatusCode: resp.StatusCode, Body: string(body), Retriable: true}
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		se.RetryAfter = d
+	}
+	return se
+}
+
+func (m *retryMatcher) matches(contentType string, body []byte) bool {
+	if m.pattern != nil {
+		return m.pattern.Match(body)
+	}
+	// A body without the field is not a soft failure.
+	v, err := extractValue(contentType, body, m.path)
+	if err != nil {
+		return false
+	}
+	vals, ok := v.([]any)
+	if !ok {
+		vals = []any{v}
+	}
+	for _, v := range vals {
+		if slices.Contains(m.values, fmt.Sprint(v)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// fieldDoc describes a config field for the generated schema. Fields are
+// keyed by their dotted JSON path, e.g. "retry.max_attempts".
+type fieldDoc struct {
+	description string
+	def         any
+	enum        []string
+}
+
+var fieldDocs = map[string]fieldDoc{
+	"endpoint":                         {description: "URL batches are sent to, or unix:///path/to.sock/request/path for a Unix socket. Required unless endpoint_template is set."},
+	"method":                           {description: "HTTP method; DELETE and GET are sent without a body.", def: "POST", enum: []string{"POST", "PUT", "PATCH", "DELETE", "GET"}},
+	"headers":                          {description: "Headers added to every request. Values containing {{ are templates rendered per batch."},
+	"multi_value_headers":              {description: "Headers sent with every listed value, after the value from headers; values may be templates like those of headers."},
+	"timeout":                          {description: "Per-attempt request timeout.", def: "30s"},
+	"connect_timeout":                  {description: "Bound on establishing a connection.", def: "30s"},
+	"tls_handshake_timeout":            {description: "Bound on the TLS handshake.", def: "10s"},
+	"response_header_timeout":          {description: "Bound on waiting for response headers once the request is sent; unset: bounded by timeout only."},
+	"batch_format":                     {description: "Request body encoding.", def: "json_array", enum: batchFormats},
+	"endpoint_template":                {description: "text/template rendering the URL per record from percent-encoded field values; records are grouped by URL."},
+	"operations":                       {description: "Per-record method and endpoint by the operation a record names."},
+	"operations.field":                 {description: "Dot-separated path of the record's operation."},
+	"operations.routes":                {description: "Route of each operation."},
+	"operations.routes.*.method":       {description: "HTTP method; defaults to method.", enum: []string{"POST", "PUT", "PATCH", "DELETE", "GET"}},
+	"operations.routes.*.endpoint":     {description: "text/template rendering the URL per record from percent-encoded field values; defaults to the session's endpoint."},
+	"operations.default":               {description: "Route of records with an unknown or no operation."},
+	"query_params":                     {description: "Query parameters added to every request URL; values may be templates."},
+	"endpoints":                        {description: "Equivalent endpoints sharing the load, in place of endpoint."},
+	"endpoints.*.url":                  {description: "Endpoint URL."},
+	"endpoints.*.weight":               {description: "Relative share of requests.", def: 1},
+	"load_balance":                     {description: "How each request's endpoint is chosen among endpoints.", def: "weighted_round_robin", enum: []string{"weighted_round_robin", "random"}},
+	"failover":                         {description: "Send requests that failed on their endpoint to the next one.", def: false},
+	"passive_health":                   {description: "Takes failing endpoints out of the rotation of endpoints until a probe succeeds."},
+	"passive_health.failure_threshold": {description: "Failed requests, 429 or 5xx responses within window that remove an endpoint; 0 disables.", def: 0},
+	"passive_health.window":            {description: "Period failures are counted over.", def: "30s"},
+	"passive_health.cooldown":          {description: "Time an endpoint stays out before a probe request.", def: "30s"},
+	"delivery_mode":                    {description: "One request per batch or per record.", def: "batch", enum: []string{"batch", "per_record"}},
+	"skip_empty_batches":               {description: "Ack batches without records without sending a request.", def: true},
+	"max_records_per_request":          {description: "Split batches into requests of at most this many records; 0 disables.", def: 0},
+	"max_body_bytes":                   {description: "Hard ceiling on encoded request bodies; larger requests are never sent and their records fail. 0 disables.", def: 0},
+	"request_deadline":                 {description: "Total time allowed for a batch across all retries; the stream deadline applies when earlier."},
+	"drain_timeout":                    {description: "How long CloseSession waits for in-flight batches.", def: "30s"},
+
+	"retry":                    {description: "Retry policy for failed sends."},
+	"retry.max_attempts":       {description: "Total attempts including the first; <= 1 disables retries.", def: 1},
+	"retry.initial_backoff":    {description: "Delay before the first retry.", def: "200ms"},
+	"retry.max_backoff":        {description: "Upper bound for retry delays.", def: "30s"},
+	"retry.multiplier":         {description: "Backoff growth factor per attempt.", def: 2},
+	"retry.jitter_strategy":    {description: "How retry delays are randomized; decorrelated grows from the previous delay.", def: "full", enum: []string{"none", "full", "equal", "decorrelated"}},
+	"retry.budget":             {description: "Caps retries to a share of requests over a sliding window."},
+	"retry.budget.ratio":       {description: "Retries allowed per request sent within the window, e.g. 0.1; 0 disables the budget.", def: 0},
+	"retry.budget.window":      {description: "Sliding window the budget is computed over.", def: "10s"},
+	"retry.budget.min_retries": {description: "Retries always allowed per window, whatever the traffic.", def: defaultBudgetMinRetries},
+
+	"auth":                    {description: "Request authentication."},
+	"auth.type":               {description: "Authentication scheme.", enum: []string{"bearer", "basic", "oauth2_client_credentials", "aws_sigv4", "jwt", "cookie_login", "api_key"}},
+	"auth.token":              {description: "Bearer token."},
+	"auth.key":                {description: "API key for api_key auth."},
+	"auth.location":           {description: "Where the API key is sent.", def: "header", enum: []string{"header", "query"}},
+	"auth.name":               {description: "Header or query parameter carrying the API key, e.g. X-API-Key."},
+	"auth.username":           {description: "Basic auth username."},
+	"auth.password":           {description: "Basic auth password."},
+	"auth.token_url":          {description: "OAuth2 token endpoint."},
+	"auth.client_id":          {description: "OAuth2 client ID."},
+	"auth.client_secret":      {description: "OAuth2 client secret."},
+	"auth.scopes":             {description: "OAuth2 scopes."},
+	"auth.access_key_id":      {description: "AWS access key ID."},
+	"auth.secret_access_key":  {description: "AWS secret access key."},
+	"auth.session_token":      {description: "AWS session token for temporary credentials."},
+	"auth.region":             {description: "AWS region."},
+	"auth.service":            {description: "AWS service name, e.g. execute-api."},
+	"auth.algorithm":          {description: "JWT signing algorithm.", enum: []string{"RS256", "ES256", "HS256"}},
+	"auth.private_key_pem":    {description: "PEM private key signing RS256 and ES256 JWTs."},
+	"auth.jwt_secret":         {description: "Shared secret signing HS256 JWTs."},
+	"auth.key_id":             {description: "JWT kid header."},
+	"auth.issuer":             {description: "JWT iss claim."},
+	"auth.subject":            {description: "JWT sub claim."},
+	"auth.audience":           {description: "JWT aud claim."},
+	"auth.ttl":                {description: "JWT lifetime; tokens are reused until close to expiry.", def: "5m"},
+	"auth.jwt_header":         {description: "Header carrying the raw JWT instead of an Authorization bearer token."},
+	"auth.login_url":          {description: "Login endpoint whose response sets the session cookies for cookie_login auth."},
+	"auth.login_method":       {description: "Login request method.", def: "POST", enum: []string{"POST", "PUT", "GET"}},
+	"auth.login_body":         {description: "Login request body, e.g. the credentials."},
+	"auth.login_content_type": {description: "Content-Type of the login body.", def: "application/json"},
+
+	"circuit_breaker":                   {description: "Per-host circuit breaker."},
+	"circuit_breaker.failure_threshold": {description: "Consecutive 429, 5xx or transport failures before opening; 0 disables."},
+	"circuit_breaker.cooldown":          {description: "Time spent open before probing.", def: "30s"},
+
+	"rate_limit":                     {description: "Per-session request rate limit."},
+	"rate_limit.requests_per_second": {description: "Sustained request rate; 0 disables."},
+	"rate_limit.burst":               {description: "Requests allowed in a burst.", def: 1},
+
+	"tls":                      {description: "Client certificates and server verification."},
+	"tls.client_cert_pem":      {description: "Inline PEM client certificate."},
+	"tls.client_key_pem":       {description: "Inline PEM client key."},
+	"tls.client_cert_file":     {description: "Path to a PEM client certificate."},
+	"tls.client_key_file":      {description: "Path to a PEM client key."},
+	"tls.ca_cert_pem":          {description: "PEM CA bundle replacing the system roots."},
+	"tls.ca_cert_file":         {description: "Path of a PEM CA bundle replacing the system roots, reloaded when the file changes."},
+	"tls.insecure_skip_verify": {description: "Disable server certificate verification (testing only).", def: false},
+	"tls.min_version":          {description: "Minimum TLS version; Go's default applies when unset.", enum: []string{"1.2", "1.3"}},
+	"tls.cipher_suites":        {description: "Allowed TLS 1.2 cipher suites by IANA name, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256."},
+
+	"transport":                         {description: "Connection pool tuning."},
+	"warm_connections":                  {description: "Connections opened to each endpoint when the session is created.", def: 0},
+	"transport.max_idle_conns":          {description: "Idle connections across all hosts.", def: 100},
+	"transport.max_idle_conns_per_host": {description: "Idle connections per host.", def: 2},
+	"transport.max_conns_per_host":      {description: "Connections per host; 0 is unlimited.", def: 0},
+	"transport.idle_conn_timeout":       {description: "How long idle connections are kept.", def: "90s"},
+	"transport.conn_wait_timeout":       {description: "How long a request waits for a connection once max_conns_per_host are busy; unset waits until the request times out."},
+	"transport.expect_continue_timeout": {description: "How long an expect_continue request waits for 100 Continue before sending its body.", def: "1s"},
+	"transport.track_conn_reuse":        {description: "Count reused and new connections per host and log a summary every minute.", def: false},
+	"transport.io_timeout":              {description: "Fail attempts whose connection makes no progress writing the request or reading the response body for this long; unset waits until timeout."},
+
+	"dialer":                   {description: "Connection establishment and recycling."},
+	"dialer.keep_alive":        {description: "TCP keep-alive period; negative disables keep-alives.", def: "30s"},
+	"dialer.conn_max_lifetime": {description: "Interval at which idle connections are closed so DNS is re-resolved; unset keeps them until idle_conn_timeout."},
+
+	"http2":                 {description: "HTTP/2 settings."},
+	"http2.enabled":         {description: "Negotiate HTTP/2 over TLS.", def: false},
+	"http2.prior_knowledge": {description: "Speak cleartext HTTP/2 (h2c) to http:// endpoints.", def: false},
+
+	"bulkhead":               {description: "Per-tenant cap on in-flight requests across sessions."},
+	"bulkhead.max_in_flight": {description: "Requests a tenant may have in flight; 0 disables."},
+	"bulkhead.policy":        {description: "Whether requests beyond the cap wait or fail.", def: "queue", enum: []string{"queue", "fail_fast"}},
+	"bulkhead.max_queued":    {description: "Requests that may wait for a slot; 0 is unbounded."},
+
+	"redirect":               {description: "Redirect handling; credentials are never forwarded to another host."},
+	"redirect.policy":        {description: "Whether redirects are followed.", def: "follow", enum: []string{"follow", "never", "same_host_only"}},
+	"redirect.max_redirects": {description: "Redirects followed before failing.", def: defaultMaxRedirects},
+
+	"receipts":             {description: "Posts a receipt of every delivered or failed batch to a monitoring endpoint."},
+	"receipts.endpoint":    {description: "Absolute http(s) URL receiving receipts."},
+	"receipts.headers":     {description: "Headers of receipt requests, e.g. for authentication."},
+	"receipts.timeout":     {description: "Timeout of each receipt request.", def: "10s"},
+	"dead_letter":          {description: "Endpoint receiving batches that failed permanently, with the failure details."},
+	"dead_letter.endpoint": {description: "Dead-letter URL; batches are POSTed as JSON."},
+	"dead_letter.headers":  {description: "Headers sent with dead-letter requests."},
+	"dead_letter.timeout":  {description: "Timeout of a dead-letter request.", def: "10s"},
+
+	"mirrors":            {description: "Additional endpoints every batch is copied to; session headers and auth are not forwarded."},
+	"mirrors.*.endpoint": {description: "Mirror URL."},
+	"mirrors.*.headers":  {description: "Headers sent to the mirror."},
+	"mirror_policy":      {description: "Whether mirror failures fail the batch.", def: "primary_only_acks", enum: []string{"primary_only_acks", "all_must_succeed"}},
+
+	"proxy":        {description: "HTTP or SOCKS5 proxy URL (http, https, socks5, socks5h); the environment is used when unset."},
+	"proxy_bypass": {description: "NO_PROXY-style hosts reached directly."},
+
+	"csv_columns":               {description: "Ordered column names for the csv format."},
+	"csv_line_terminator":       {description: "CSV line terminator.", def: "\n", enum: []string{"\n", "\r\n"}},
+	"content_type":              {description: "Content-Type of request bodies; overrides headers and the batch format default."},
+	"accept":                    {description: "Accept header of requests; overrides headers.", def: defaultAccept},
+	"expect_continue":           {description: "Send Expect: 100-continue so the server can reject requests before the body is uploaded.", def: false},
+	"user_agent":                {description: "User-Agent header of requests; overrides headers. Default is planx-plugin-http/<version>."},
+	"stream_body":               {description: "Encode json_array and ndjson bodies while sending them (chunked).", def: false},
+	"compression":               {description: "Request body compression, sent as Content-Encoding.", enum: []string{"gzip", "zstd", "br"}},
+	"compression_level":         {description: "Compression level: 1-9 for gzip, 1-22 for zstd, 1-11 for br; 0 for the algorithm default.", def: 0},
+	"ndjson_delimiter":          {description: "Separator between ndjson records.", def: "\n"},
+	"ndjson_trailing_delimiter": {description: "Write the delimiter after the last ndjson record.", def: true},
+	"record_wrapper":            {description: "text/template decorating each ndjson line; .Payload is the raw record JSON."},
+	"xml_root_element":          {description: "Root element for the xml format.", def: defaultXMLRootElement},
+	"xml_record_element":        {description: "Per-record element for the xml format.", def: defaultXMLRecordElement},
+
+	"envelope_key":               {description: "Key under which the json_array records are nested in an object."},
+	"envelope_fields":            {description: "Static JSON fields added next to envelope_key."},
+	"envelope_template":          {description: "Template wrapping the json_array body; .Records is the records array, .Count their number."},
+	"bulk_action":                {description: "text/template for the action line preceding each bulk document, evaluated like record_wrapper.", def: defaultBulkAction},
+	"bulk_check_items":           {description: "Parse bulk responses and fail only the rejected items.", def: false},
+	"multi_status":               {description: "Fails only the records rejected in 207 Multi-Status responses."},
+	"multi_status.mode":          {description: "Body format of 207 responses; empty disables parsing.", enum: []string{"json"}},
+	"multi_status.items":         {description: "Path of the array holding one result per record, in request order; \"$\" for a top-level array.", def: "$.results"},
+	"multi_status.status_field":  {description: "Result field holding its HTTP status; results without a 2xx status fail.", def: "status"},
+	"multi_status.message_field": {description: "Result field describing a failure, reported in the batch error.", def: "error"},
+
+	"graphql_query":                {description: "GraphQL mutation sent with every batch, e.g. mutation($records: [EventInput!]!) { ingest(events: $records) { id } }."},
+	"graphql_variable":             {description: "GraphQL variable holding the batch's records.", def: defaultGraphQLVariable},
+	"protobuf":                     {description: "Settings of the protobuf batch format."},
+	"protobuf.descriptor_set":      {description: "Base64 FileDescriptorSet defining the messages, as written by protoc --descriptor_set_out --include_imports."},
+	"protobuf.descriptor_set_file": {description: "Path of a FileDescriptorSet file, in place of descriptor_set."},
+	"protobuf.message":             {description: "Full name of the record message; records are JSON in its protobuf JSON mapping."},
+	"protobuf.packing":             {description: "How records are packed into the body.", def: "delimited", enum: []string{"delimited", "repeated"}},
+	"protobuf.batch_message":       {description: "Full name of the message wrapping the batch, for repeated packing."},
+	"protobuf.batch_field":         {description: "Repeated field of batch_message holding the records."},
+
+	"multipart_files":             {description: "Upload one file part per record or one JSON array part per batch.", def: "per_record", enum: []string{"per_record", "batch"}},
+	"multipart_file_field":        {description: "Form field name of file parts.", def: defaultMultipartFileField},
+	"multipart_filename":          {description: "text/template for file part names, evaluated like record_wrapper.", def: defaultMultipartFilename},
+	"multipart_file_content_type": {description: "Content-Type of file parts.", def: defaultMultipartContentType},
+	"multipart_fields":            {description: "Static form fields sent with every multipart body."},
+
+	"ack_detail":                        {description: "Let successful acks carry detail JSON-encoded in their error field.", def: false},
+	"capture_response":                  {description: "Report successful response bodies in the ack.", def: false},
+	"max_response_bytes":                {description: "Truncation limit for captured and error bodies.", def: defaultMaxResponseBytes},
+	"response_extract":                  {description: "Path of values in successful JSON or XML responses reported per record in the ack, e.g. $.data.ids."},
+	"event_time":                        {description: "Sends the range of the records' event times in headers on each batch's requests."},
+	"event_time.field":                  {description: "Dot-separated record field holding the event time."},
+	"event_time.format":                 {description: "Encoding of the event time field.", def: "rfc3339", enum: []string{"rfc3339", "unix", "unix_ms"}},
+	"event_time.min_header":             {description: "Header carrying the earliest event time, in RFC 3339.", def: "X-Event-Time-Min"},
+	"event_time.max_header":             {description: "Header carrying the latest event time, in RFC 3339.", def: "X-Event-Time-Max"},
+	"event_time.on_missing":             {description: "Whether records without an event time are left out of the range or fail the batch.", def: "skip", enum: []string{"skip", "error"}},
+	"cursor":                            {description: "Follows cursors of asynchronous endpoints until their operation completes before acking."},
+	"cursor.path":                       {description: "Path of the cursor in responses, e.g. $.next; a response without one completes the batch."},
+	"cursor.method":                     {description: "Method of follow-up requests.", def: "GET", enum: []string{"GET", "POST", "PUT", "PATCH", "DELETE"}},
+	"cursor.endpoint":                   {description: "Template of the follow-up URL with the cursor as .Cursor; unset requests the cursor as a URL."},
+	"cursor.interval":                   {description: "Wait before each follow-up request.", def: "1s"},
+	"cursor.max_iterations":             {description: "Follow-ups before a batch whose operation is still incomplete fails.", def: defaultCursorMaxIterations},
+	"allow_retry_non_idempotent":        {description: "Retry POST and PATCH requests that may have been processed, e.g. after a timeout, risking duplicates.", def: false},
+	"idempotency_key_header":            {description: "Header carrying a per-request key that is stable across retries."},
+	"record_count_header":               {description: "Header carrying the number of records in each request."},
+	"checksum_header":                   {description: "Header carrying a checksum of each request body as sent."},
+	"checksum_algorithm":                {description: "Checksum algorithm.", def: "sha256", enum: []string{"sha256", "md5"}},
+	"checksum_encoding":                 {description: "Checksum encoding; base64 md5 suits Content-MD5.", def: "hex", enum: []string{"hex", "base64"}},
+	"retry_response_match":              {description: "Retries successful responses whose body signals a transient failure."},
+	"retry_response_match.path":         {description: "JSON or XML path of the value compared with values, e.g. \"$.status\"."},
+	"retry_response_match.values":       {description: "Values at path that trigger a retry, e.g. [\"throttled\"]."},
+	"retry_response_match.pattern":      {description: "Regular expression over the raw body that triggers a retry; exclusive with path."},
+	"response_validator":                {description: "Status, header and body checks responses must pass, evaluated in that order after success_status_codes and retry_response_match and before multi_status and bulk_check_items; the first failing check fails the request."},
+	"response_validator.status":         {description: "Status codes accepted, among those success_status_codes accepts."},
+	"response_validator.headers":        {description: "Headers responses must carry with the given value, or any value when empty, e.g. {\"X-Processed\": \"true\"}."},
+	"response_validator.body":           {description: "Assertions on JSON or XML response bodies, checked in order."},
+	"response_validator.body.*.path":    {description: "Path of the asserted value, e.g. $.status."},
+	"response_validator.body.*.equals":  {description: "Values the asserted value must be one of."},
+	"response_validator.body.*.pattern": {description: "Regular expression the asserted value must match; exclusive with equals."},
+	"response_validator.retriable":      {description: "Retry requests whose response fails validation.", def: false},
+	"success_status_codes":              {description: "Status codes treated as success; default is any code below 400."},
+	"retriable_status_codes":            {description: "Failure status codes that are retried; default is 429 and 5xx."},
+	"backpressure_mode":                 {description: "How a slow endpoint pushes back: block stops reading batches at capacity; signal also marks acks \"throttled\".", def: "block", enum: []string{"block", "signal"}},
+	"max_concurrent_requests":           {description: "Batches delivered concurrently per session.", def: 1},
+	"max_queued_batches":                {description: "Batches of the session received but not yet acked; 0 is unlimited.", def: 0},
+	"queue_full_policy":                 {description: "What happens to batches arriving at a full queue.", def: "block", enum: []string{"block", "reject"}},
+
+	"adaptive_concurrency":                {description: "Adapt the session's concurrency to endpoint latency and errors (AIMD)."},
+	"adaptive_concurrency.enabled":        {description: "Replace max_concurrent_requests with an adaptive limit.", def: false},
+	"adaptive_concurrency.min_limit":      {description: "Lowest and initial concurrency limit.", def: 1},
+	"adaptive_concurrency.max_limit":      {description: "Highest concurrency limit.", def: defaultAdaptiveMaxLimit},
+	"adaptive_concurrency.latency_target": {description: "Batches slower than this reduce the limit; unset reacts to errors only."},
+
+	"max_aggregate_records":         {description: "Pending records that trigger sending aggregated batches; 0 flushes on time only."},
+	"max_aggregate_wait":            {description: "Enables aggregation: how long batches are buffered before being sent together."},
+	"ordered":                       {description: "Send concurrently delivered batches in receive order.", def: false},
+	"dedup":                         {description: "Drops records the session already delivered."},
+	"dedup.key":                     {description: "Dot-separated field identifying a record, or \"$\" for a hash of the whole payload."},
+	"dedup.size":                    {description: "Most recently delivered keys remembered.", def: defaultDedupSize},
+	"dedup.ttl":                     {description: "How long a delivered key is remembered.", def: "10m"},
+	"redact":                        {description: "Fields removed or masked before records are sent."},
+	"redact.*.path":                 {description: "Dot-separated field path; arrays along the path are traversed."},
+	"redact.*.strategy":             {description: "How the field is redacted.", def: "drop", enum: []string{"drop", "mask", "hash"}},
+	"redact.*.mask":                 {description: "Replacement value for the mask strategy.", def: defaultRedactMask},
+	"transform":                     {description: "Reshapes each JSON record before it is formatted."},
+	"transform.mappings":            {description: "Values copied from one path of the record to another."},
+	"transform.mappings.*.from":     {description: "Dot-separated source path, or \"$\" for the whole record."},
+	"transform.mappings.*.to":       {description: "Dot-separated destination path."},
+	"transform.mappings.*.optional": {description: "Skip the mapping when the source is missing instead of failing the record.", def: false},
+	"transform.set":                 {description: "Literal JSON values by destination path."},
+	"transform.keep":                {description: "Start from the record, moving mapped fields, instead of an empty object.", def: false},
+	"record_schema":                 {description: "JSON Schema each record must satisfy after redaction and transform."},
+	"on_schema_violation":           {description: "What to do with records violating record_schema.", def: "fail", enum: []string{"fail", "drop", "dead_letter"}},
+	"redact_headers":                {description: "Additional headers masked in logs."},
+	"debug_body_bytes":              {description: "Body bytes included in debug request and response logs.", def: defaultDebugBodyBytes},
+	"dry_run":                       {description: "Log requests instead of sending them.", def: false},
+}
+
+// ConfigSchema returns a JSON Schema describing Config. The structure is
+// derived from the Config type itself, so it cannot drift from what
+// CreateSession accepts; descriptions and defaults come from fieldDocs.
+func ConfigSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeFor[Config](), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "HTTP sink configuration"
+	schema["anyOf"] = []any{
+		map[string]any{"required": []string{"endpoint"}},
+		map[string]any{"required": []string{"endpoint_template"}},
+		map[string]any{"required": []string{"endpoints"}},
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// DescribeConfig returns the JSON Schema of the session config, as
+// ConfigSchema does. The planx.v1 SinkPlugin service has no RPC to carry
+// it, and its definition lives in planx-proto, so hosts read it from the
+// health server's /config-schema or the -describe-config flag instead.
+func (s *HTTPSink) DescribeConfig(ctx context.Context) ([]byte, error) {
+	return ConfigSchema()
+}
+
+// typeSchema returns the schema of t, found at the dotted path in Config.
+func typeSchema(t reflect.Type, path string) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	s := map[string]any{}
+	if t == reflect.TypeFor[json.RawMessage]() {
+		return describe(s, path) // any JSON value
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			props[name] = typeSchema(f.Type, strings.TrimPrefix(path+"."+name, "."))
+		}
+		s["type"] = "object"
+		s["properties"] = props
+	case reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = typeSchema(t.Elem(), path+".*")
+	case reflect.Slice:
+		s["type"] = "array"
+		s["items"] = typeSchema(t.Elem(), path+".*")
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	}
+
+	return describe(s, path)
+}
+
+// describe adds the fieldDocs entry for path to s.
+func describe(s map[string]any, path string) map[string]any {
+	if doc, ok := fieldDocs[path]; ok {
+		s["description"] = doc.description
+		if doc.def != nil {
+			s["default"] = doc.def
+		}
+		if doc.enum != nil {
+			s["enum"] = doc.enum
+		}
+	}
+	return s
+}
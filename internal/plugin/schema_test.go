@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// configPaths returns the dotted path of every field under t, as typeSchema
+// names them, with "*" for map and slice elements.
+func configPaths(t reflect.Type, path string, paths map[string]bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[json.RawMessage]() {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			p := strings.TrimPrefix(path+"."+name, ".")
+			paths[p] = true
+			configPaths(f.Type, p, paths)
+		}
+	case reflect.Map, reflect.Slice:
+		configPaths(t.Elem(), path+".*", paths)
+	}
+}
+
+func TestFieldDocsCoverConfig(t *testing.T) {
+	paths := map[string]bool{}
+	configPaths(reflect.TypeFor[Config](), "", paths)
+
+	var missing, stale []string
+	for p := range paths {
+		if _, ok := fieldDocs[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	for p := range fieldDocs {
+		if !paths[p] {
+			stale = append(stale, p)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	for _, p := range missing {
+		t.Errorf("config field %s has no fieldDocs entry", p)
+	}
+	for _, p := range stale {
+		t.Errorf("fieldDocs entry %s names no config field", p)
+	}
+}
+
+func TestDescribeConfig(t *testing.T) {
+	schema, err := NewHTTPSink().DescribeConfig(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		Properties map[string]struct {
+			Description string `json:"description"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if s.Properties["endpoint"].Description == "" {
+		t.Errorf("endpoint has no description in %s", schema)
+	}
+}
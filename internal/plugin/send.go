@@ -0,0 +1,568 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/planx-lab/planx-common/logger"
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// sendBatch delivers b per the session's delivery mode and endpoint
+// settings. Responses worth reporting in the ack are collected into d.
+func (s *HTTPSink) sendBatch(ctx context.Context, state *sessionState, b batch.Batch, d *delivery) (err error) {
+	ctx, span := startBatchSpan(ctx, state, len(b.Records))
+	defer func() { endBatchSpan(span, err) }()
+
+	// The request deadline bounds all attempts of the batch. An earlier
+	// deadline on the incoming stream applies regardless.
+	if state.requestDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, state.requestDeadline)
+		defer cancel()
+	}
+
+	// A batch is not worth delivering past its deliver-by deadline.
+	if t, ok := deliverBy(ctx); ok {
+		if !time.Now().Before(t) {
+			return fmt.Errorf("%w: deliver-by deadline %s passed before the batch was sent", errExpired, t.Format(time.RFC3339))
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, t)
+		defer cancel()
+		defer func() {
+			if err != nil && !time.Now().Before(t) {
+				err = fmt.Errorf("%w: deliver-by deadline %s passed: %w", errExpired, t.Format(time.RFC3339), err)
+			}
+		}()
+	}
+
+	if state.redactor != nil || state.transform != nil || state.schema != nil || state.dedup != nil {
+		return s.sendPrepared(ctx, state, b, d)
+	}
+	return s.deliver(ctx, state, b, d)
+}
+
+// deliver sends b to the primary endpoint and any mirrors.
+func (s *HTTPSink) deliver(ctx context.Context, state *sessionState, b batch.Batch, d *delivery) error {
+	if len(b.Records) == 0 && (state.cfg.SkipEmptyBatches == nil || *state.cfg.SkipEmptyBatches) {
+		return nil
+	}
+	if state.mirrors != nil {
+		return s.deliverMirrored(ctx, state, b, d)
+	}
+	return s.deliverPrimary(ctx, state, b, d)
+}
+
+// deliverPrimary renders, encodes and sends b to the session's endpoint.
+func (s *HTTPSink) deliverPrimary(ctx context.Context, state *sessionState, b batch.Batch, d *delivery) error {
+	cfg := state.cfg
+
+	header, err := state.headers.render(state, b)
+	if err != nil {
+		return err
+	}
+	if state.eventTime != nil {
+		if header, err = state.eventTime.apply(header, b); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DeliveryMode == "per_record" {
+		return s.sendRecords(ctx, state, header, b, d)
+	}
+
+	groups, err := groupByEndpoint(state, b)
+	if err != nil {
+		return err
+	}
+
+	if len(groups) == 1 {
+		query, err := state.query.render(state, groups[0].batch)
+		if err != nil {
+			return err
+		}
+		p, err := encodeFor(state, groups[0].method, groups[0].batch)
+		if err != nil {
+			return err
+		}
+		if err := checkBodySize(state, p, groups[0].batch, groups[0].indices); err != nil {
+			return err
+		}
+		err = s.sendBalanced(ctx, state, d, outgoing{method: groups[0].method, url: groups[0].url, query: query, header: header, payload: p, indices: groups[0].indices})
+		var ie *itemError
+		if errors.As(err, &ie) {
+			perr := &partialError{total: len(b.Records)}
+			for _, i := range ie.indices {
+				perr.add(i, ie.first)
+			}
+			return perr
+		}
+		return err
+	}
+
+	// Fan out: each endpoint, or each request of at most
+	// MaxRecordsPerRequest records, receives its own sub-batch.
+	perr := &partialError{total: len(b.Records)}
+	for _, g := range groups {
+		query, err := state.query.render(state, g.batch)
+		var p payload
+		if err == nil {
+			p, err = encodeFor(state, g.method, g.batch)
+		}
+		if err == nil {
+			err = checkBodySize(state, p, g.batch, g.indices)
+		}
+		if err == nil {
+			err = s.sendBalanced(ctx, state, d, outgoing{method: g.method, url: g.url, query: query, header: header, payload: p, indices: g.indices})
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			var ie *itemError
+			if errors.As(err, &ie) {
+				for _, i := range ie.indices {
+					perr.add(i, ie.first)
+				}
+				continue
+			}
+			for _, i := range g.indices {
+				perr.add(i, err)
+			}
+		}
+	}
+	if len(perr.failed) > 0 {
+		return perr
+	}
+	return nil
+}
+
+// sendPrepared deduplicates, redacts, transforms and validates b before
+// delivering it. Records that cannot be prepared are never sent and are
+// reported as failed alongside any delivery failures of the others; records
+// violating the record schema are handled by the OnSchemaViolation policy.
+func (s *HTTPSink) sendPrepared(ctx context.Context, state *sessionState, b batch.Batch, d *delivery) error {
+	p := prepareRecords(state, b)
+	if len(p.invalid.failed) > 0 {
+		if err := s.handleSchemaViolations(ctx, state, b, d, p.invalid); err != nil {
+			return err
+		}
+	}
+	if p.duplicates > 0 {
+		if state.cfg.AckDetail {
+			d.noteDuplicates(p.duplicates)
+		}
+		duplicatesDroppedTotal.WithLabelValues(state.tenantID).Add(float64(p.duplicates))
+	}
+
+	perr := p.failed
+	if len(p.kept) == len(b.Records) {
+		err := s.deliver(ctx, state, p.records, d)
+		if state.dedup != nil {
+			state.dedup.remember(p.keys, err)
+		}
+		return err
+	}
+
+	if len(p.records.Records) > 0 {
+		// Delivery reports positions within p.records; map them back to b.
+		var sub delivery
+		err := s.deliver(ctx, state, p.records, &sub)
+		d.merge(&sub, p.kept)
+		if state.dedup != nil {
+			state.dedup.remember(p.keys, err)
+		}
+
+		var subErr *partialError
+		switch {
+		case err == nil:
+		case errors.As(err, &subErr):
+			for _, i := range subErr.failed {
+				perr.add(p.kept[i], subErr.first)
+			}
+		default:
+			for _, i := range p.kept {
+				perr.add(i, err)
+			}
+		}
+	}
+	// A duplicate within the batch shares the fate of its original.
+	for dup, orig := range p.dupOf {
+		if slices.Contains(perr.failed, orig) {
+			perr.add(dup, fmt.Errorf("record %d: duplicate of failed record %d", dup, orig))
+		}
+	}
+	slices.Sort(perr.failed)
+	if len(perr.failed) == 0 {
+		return nil
+	}
+	return perr
+}
+
+// prepared is the outcome of prepareRecords.
+type prepared struct {
+	records    batch.Batch // the records to send
+	kept       []int       // index in the batch of each record to send
+	keys       []string    // dedup key of each record to send; nil without dedup
+	failed     *partialError
+	invalid    *partialError // records violating the record schema
+	duplicates int           // records left out as already delivered
+	dupOf      map[int]int   // index of each duplicate within b -> index of its original
+}
+
+// prepareRecords returns the records of b to send, redacted and
+// transformed. Duplicates are left out, as are records that cannot be
+// prepared or violate the record schema, which are reported in failed and
+// invalid respectively.
+func prepareRecords(state *sessionState, b batch.Batch) prepared {
+	p := prepared{
+		records: batch.Batch{Records: make([]batch.Record, 0, len(b.Records))},
+		kept:    make([]int, 0, len(b.Records)),
+		failed:  &partialError{total: len(b.Records)},
+		invalid: &partialError{total: len(b.Records)},
+	}
+
+	var inBatch map[string]int // key -> index of its first record
+	for i, rec := range b.Records {
+		var key string
+		if state.dedup != nil {
+			// Keyed on the record as received, before redaction can drop
+			// the key field.
+			if key = state.dedup.key(rec.Payload); key != "" {
+				if orig, ok := inBatch[key]; ok {
+					if p.dupOf == nil {
+						p.dupOf = make(map[int]int)
+					}
+					p.dupOf[i] = orig
+					p.duplicates++
+					continue
+				}
+				if state.dedup.seen(key) {
+					p.duplicates++
+					continue
+				}
+				if inBatch == nil {
+					inBatch = make(map[string]int)
+				}
+				inBatch[key] = i
+			}
+		}
+
+		var err error
+		if state.redactor != nil {
+			if rec.Payload, err = state.redactor.redactPayload(rec.Payload); err != nil {
+				p.failed.add(i, fmt.Errorf("record %d: redaction failed: %w", i, err))
+				continue
+			}
+		}
+		if state.transform != nil {
+			if rec.Payload, err = state.transform.apply(rec.Payload); err != nil {
+				p.failed.add(i, fmt.Errorf("record %d: transform failed: %w", i, err))
+				continue
+			}
+		}
+		if state.schema != nil {
+			if err := state.schema.validate(rec.Payload); err != nil {
+				p.invalid.add(i, fmt.Errorf("record %d: violates record_schema %w", i, err))
+				continue
+			}
+		}
+		p.records.Records = append(p.records.Records, rec)
+		p.kept = append(p.kept, i)
+		if state.dedup != nil {
+			p.keys = append(p.keys, key)
+		}
+	}
+	return p
+}
+
+// sendRecords sends each record in its own request. Every record is
+// attempted; failures are collected into a *partialError.
+func (s *HTTPSink) sendRecords(ctx context.Context, state *sessionState, header http.Header, b batch.Batch, d *delivery) error {
+	perr := &partialError{total: len(b.Records)}
+	for i := range b.Records {
+		method, endpoint, err := recordRoute(state, b, i)
+		if err != nil {
+			return err
+		}
+
+		query, err := state.query.render(state, batch.Batch{Records: b.Records[i : i+1]})
+		var p payload
+		if err == nil {
+			p, err = encodeRecordFor(state, method, b, i)
+		}
+		if err == nil {
+			err = checkBodySize(state, p, batch.Batch{Records: b.Records[i : i+1]}, []int{i})
+		}
+		if err == nil {
+			err = s.sendBalanced(ctx, state, d, outgoing{method: method, url: endpoint, query: query, header: header, payload: p, indices: []int{i}})
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			perr.add(i, err)
+		}
+	}
+	if len(perr.failed) > 0 {
+		return perr
+	}
+	return nil
+}
+
+// outgoing describes a single HTTP request, resent as-is on retry.
+type outgoing struct {
+	method  string
+	url     string
+	query   url.Values  // configured query parameters, rendered for this request
+	header  http.Header // configured headers, rendered for this batch
+	payload payload
+	indices []int // positions of the request's records in the batch
+	mirror  bool  // sent to a mirror: no session auth, rate limit or response capture
+}
+
+// sendWithRetry sends o, retrying retriable failures per the session's
+// retry policy. Every attempt carries the same idempotency key, if enabled.
+func (s *HTTPSink) sendWithRetry(ctx context.Context, state *sessionState, d *delivery, o outgoing) error {
+	defer o.payload.release()
+
+	if state.compressor != nil {
+		p, err := state.compressor.compress(o.payload)
+		if err != nil {
+			return err
+		}
+		o.payload = p
+		defer o.payload.release()
+	}
+	if name := state.cfg.IdempotencyKeyHeader; name != "" {
+		o.header = o.header.Clone()
+		o.header.Set(name, uuid.NewString())
+	}
+	if state.cfg.RecordCountHeader != "" || state.cfg.ChecksumHeader != "" {
+		o.header = o.header.Clone()
+		setIntegrityHeaders(state.cfg, o.header, o)
+	}
+
+	if state.retry.budget != nil {
+		state.retry.budget.request(state)
+	}
+
+	d.noteURL(o.url)
+	attempt := 0
+	defer func() { d.noteAttempts(attempt) }()
+	var delay time.Duration
+	relogged, resetRetried := false, false
+	for attempt = 1; ; attempt++ {
+		sentAt := time.Now()
+		err := s.doRequest(ctx, state, d, o)
+		if err != nil && !relogged && reauthenticate(state, err, sentAt) {
+			// The request was rejected unprocessed; resend it once with
+			// fresh credentials.
+			relogged = true
+			err = s.doRequest(ctx, state, d, o)
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt >= state.retry.maxAttempts || !isRetriable(ctx, err) {
+			if attempt > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		if !isIdempotent(o.method) && !state.cfg.AllowRetryNonIdempotent && state.cfg.IdempotencyKeyHeader == "" &&
+			maybeProcessed(err) {
+			return fmt.Errorf("not retrying %s after attempt %d as the endpoint may have processed it; "+
+				"set idempotency_key_header, or allow_retry_non_idempotent to accept duplicates: %w", o.method, attempt, err)
+		}
+		if state.retry.budget != nil && !state.retry.budget.retry(state) {
+			return fmt.Errorf("%w, not retrying after attempt %d: %w", errRetryBudget, attempt, err)
+		}
+
+		if isConnReset(err) && !resetRetried {
+			// A connection recycled under the request says nothing about
+			// the endpoint's health; retry it right away, once.
+			resetRetried = true
+			delay = 0
+		} else {
+			delay = state.retry.delay(attempt, delay, err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%w: giving up after %d attempts, next retry would pass the request deadline: %w",
+				context.DeadlineExceeded, attempt, err)
+		}
+		logger.Warn().
+			Err(err).
+			Str("session_id", state.id).
+			Int("attempt", attempt).
+			Dur("backoff", delay).
+			Msg("Retrying HTTP send")
+
+		state.stats.retries.Add(1)
+		state.stats.retrying.Add(1)
+		err = sleepContext(ctx, delay)
+		state.stats.retrying.Add(-1)
+		if err != nil {
+			return fmt.Errorf("retry aborted after %d attempts: %w", attempt, err)
+		}
+	}
+}
+
+// doRequest performs a single attempt of o.
+func (s *HTTPSink) doRequest(ctx context.Context, state *sessionState, d *delivery, o outgoing) error {
+	req, err := http.NewRequestWithContext(ctx, o.method, o.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if o.query != nil {
+		mergeQuery(req.URL, o.query)
+	}
+	o.payload.setBody(req)
+	// The transport closes the body once Do is called; close it ourselves
+	// on any earlier return so a pooled payload can be released.
+	sent := false
+	defer func() {
+		if !sent {
+			req.Body.Close()
+		}
+	}()
+
+	// Set headers. Content-Type precedence: the content_type setting, then a
+	// Content-Type in headers, then the batch format's default.
+	if o.payload.contentType != "" {
+		req.Header.Set("Content-Type", o.payload.contentType)
+	}
+	for k, v := range o.header {
+		req.Header[k] = slices.Clone(v)
+	}
+	if state.cfg.ContentType != "" && o.payload.contentType != "" {
+		req.Header.Set("Content-Type", state.cfg.ContentType)
+	}
+	if o.payload.encoding != "" {
+		req.Header.Set("Content-Encoding", o.payload.encoding)
+	}
+	setUserAgent(state, req.Header)
+	if state.cfg.ExpectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
+	if state.cfg.Accept != "" {
+		req.Header.Set("Accept", state.cfg.Accept)
+	} else if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", defaultAccept)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	if state.cfg.DryRun {
+		size := len(o.payload.body)
+		if o.payload.stream != nil {
+			var cw countingWriter
+			if err := o.payload.stream(&cw); err != nil {
+				return err
+			}
+			size = cw.n
+		}
+		logDryRun(state, req, size)
+		return nil
+	}
+
+	if state.limiter != nil && !o.mirror {
+		if err := state.limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit wait: %w", err)
+		}
+	}
+
+	if state.cfg.Bulkhead.MaxInFlight > 0 {
+		bh := s.bulkheads.get(state.tenantID, state.cfg.Bulkhead)
+		if err := bh.acquire(ctx); err != nil {
+			return err
+		}
+		defer bh.release()
+	}
+
+	if state.auth != nil && !o.mirror {
+		if err := state.auth.apply(ctx, req); err != nil {
+			return err
+		}
+	}
+	injectTraceContext(ctx, req)
+
+	var breaker *circuitBreaker
+	if state.breaker.threshold > 0 {
+		breaker = s.breakers.get(state.id, req.URL.Host, state.breaker)
+		state.stats.hosts.Store(req.URL.Host, struct{}{})
+		if !breaker.allow() {
+			return fmt.Errorf("%w for %s", errCircuitOpen, req.URL.Host)
+		}
+	}
+
+	logRequest(state, req, o.payload.body)
+
+	var stall *stallTimer
+	if state.ioTimeout > 0 {
+		var stop func()
+		req, stall, stop = withStallTimer(req, state.ioTimeout)
+		defer stop()
+	}
+
+	start := time.Now()
+	sent = true
+	state.stats.bytesSent.Add(int64(len(o.payload.body)))
+	resp, err := state.client.Do(req)
+	if err != nil {
+		if stall != nil {
+			err = stall.err(err)
+		}
+		observeRequest(o.method, state.tenantID, 0, len(o.payload.body), time.Since(start))
+		if breaker != nil {
+			if ctx.Err() != nil {
+				breaker.release()
+			} else {
+				breaker.record(false)
+			}
+		}
+		if state.balancer != nil && !o.mirror && ctx.Err() == nil {
+			state.balancer.observe(state, o.url, false)
+		}
+		var se *streamError
+		if errors.As(err, &se) {
+			return se
+		}
+		return fmt.Errorf("HTTP request failed: %w", redactAPIKey(state.auth, err))
+	}
+	if stall != nil {
+		stall.progress()
+		resp.Body = stall.wrap(resp.Body)
+	}
+	// Drain whatever we do not read so the connection can be reused.
+	defer decodeResponse(resp)()
+	observeRequest(o.method, state.tenantID, resp.StatusCode, len(o.payload.body), time.Since(start))
+	logResponse(state, resp)
+	recordStatus(ctx, resp.StatusCode)
+	if breaker != nil {
+		breaker.record(healthyStatus(resp.StatusCode))
+	}
+	if state.balancer != nil && !o.mirror {
+		state.balancer.observe(state, o.url, healthyStatus(resp.StatusCode))
+	}
+
+	if err := state.evaluator.evaluate(state, resp, o.indices); err != nil {
+		return err
+	}
+
+	if state.cursor != nil && !o.mirror {
+		return s.followCursor(ctx, state, d, resp, o)
+	}
+	if (state.cfg.CaptureResponse || state.extractPath != nil) && !o.mirror {
+		d.record(state, resp, o.indices)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// teardownWindow is how soon a cancelled send must return.
+const teardownWindow = time.Second
+
+func TestSendCancelledDuringRequest(t *testing.T) {
+	arrived := make(chan struct{})
+	tornDown := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body
+		// has been read.
+		io.Copy(io.Discard, r.Body)
+		close(arrived)
+		select {
+		case <-r.Context().Done():
+			close(tornDown)
+		case <-time.After(30 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{"endpoint": %q, "timeout": "60s"}`, srv.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := send(ctx, s, state, testBatch(`{"id":1}`))
+	if elapsed := time.Since(start); elapsed > teardownWindow {
+		t.Errorf("send returned %v after the stream was cancelled, want within %v", elapsed, teardownWindow)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-tornDown:
+	case <-time.After(teardownWindow):
+		t.Error("the upstream request was not torn down")
+	}
+}
+
+func TestSendCancelledDuringRetrySleep(t *testing.T) {
+	var attempts atomic.Int32
+	responded := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		responded <- struct{}{}
+	}))
+	defer srv.Close()
+
+	s, state := newTestSession(t, fmt.Sprintf(`{
+		"endpoint": %q,
+		"timeout": "60s",
+		"retry": {"max_attempts": 3, "initial_backoff": "30s", "jitter_strategy": "none"}
+	}`, srv.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-responded
+		// Let the send enter its backoff before cancelling.
+		for state.stats.retrying.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := send(ctx, s, state, testBatch(`{"id":1}`))
+	if elapsed := time.Since(start); elapsed > teardownWindow {
+		t.Errorf("send returned %v after the stream was cancelled, want within %v", elapsed, teardownWindow)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("server saw %d attempts, want 1", n)
+	}
+	if n := state.stats.retrying.Load(); n != 0 {
+		t.Errorf("%d sends still counted as retrying", n)
+	}
+}
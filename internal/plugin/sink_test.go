@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// newTestSession returns a sink and the state of a session created with
+// configJSON, released when the test ends.
+func newTestSession(t testing.TB, configJSON string) (*HTTPSink, *sessionState) {
+	t.Helper()
+	s := NewHTTPSink()
+	state, err := s.newSessionState("tenant", []byte(configJSON))
+	if err != nil {
+		t.Fatalf("newSessionState(%s): %v", configJSON, err)
+	}
+	state.id = "session"
+	t.Cleanup(func() { s.clients.release(state.clientKey) })
+	return s, state
+}
+
+// testBatch returns a batch of the given JSON record payloads.
+func testBatch(payloads ...string) batch.Batch {
+	var b batch.Batch
+	for _, p := range payloads {
+		b.Records = append(b.Records, batch.Record{Payload: []byte(p)})
+	}
+	return b
+}
+
+// send sends b through the session as a Write would, returning the send
+// error and the delivery it recorded.
+func send(ctx context.Context, s *HTTPSink, state *sessionState, b batch.Batch) (*delivery, error) {
+	var d delivery
+	err := s.sendBatch(ctx, state, b, &d)
+	return &d, err
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// isSOCKSProxy reports whether proxyURL names a SOCKS5 proxy.
+func isSOCKSProxy(proxyURL string) bool {
+	u, err := url.Parse(proxyURL)
+	return err == nil && (u.Scheme == "socks5" || u.Scheme == "socks5h")
+}
+
+// newSOCKSDial routes dial through the SOCKS5 proxy at proxyURL, which may
+// carry a user name and password. Hosts matched by bypass are dialed
+// directly. socks5 resolves host names locally, socks5h leaves that to the
+// proxy.
+func newSOCKSDial(proxyURL string, bypass []string, dial dialFunc) (dialFunc, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", redactURL(proxyURL))
+	}
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, auth, contextDialer(dial))
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", redactURL(proxyURL), err)
+	}
+	socks := d.(proxy.ContextDialer)
+
+	useProxy := (&httpproxy.Config{
+		HTTPProxy: proxyURL,
+		NoProxy:   strings.Join(bypass, ","),
+	}).ProxyFunc()
+	localDNS := u.Scheme == "socks5"
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p, _ := useProxy(&url.URL{Scheme: "http", Host: addr}); p == nil {
+			return dial(ctx, network, addr)
+		}
+		if localDNS {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if net.ParseIP(host) == nil {
+				ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+				if err != nil {
+					return nil, err
+				}
+				addr = net.JoinHostPort(ips[0].IP.String(), port)
+			}
+		}
+		return socks.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// contextDialer adapts a dialFunc to the proxy package, which uses
+// DialContext when available.
+type contextDialer dialFunc
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
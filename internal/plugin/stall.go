@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+var errConnStalled = errors.New("connection stalled")
+
+// parseIOTimeout returns zero when transport.io_timeout is unset.
+func parseIOTimeout(tc TransportConfig) (time.Duration, error) {
+	if tc.IOTimeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(tc.IOTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid transport.io_timeout %q", tc.IOTimeout)
+	}
+	return d, nil
+}
+
+// stallTimer cancels an attempt whose connection makes no progress for its
+// timeout while the request is written or the response body read. The wait
+// for response headers is left to response_header_timeout.
+type stallTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// withStallTimer returns req bound to a stall timer, which starts once
+// req has a connection, and a func stopping it. The returned request must
+// be the one sent.
+func withStallTimer(req *http.Request, timeout time.Duration) (*http.Request, *stallTimer, func()) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	st := &stallTimer{timeout: timeout}
+	st.timer = time.AfterFunc(timeout, func() {
+		st.stalled.Store(true)
+		cancel(errConnStalled)
+	})
+	st.timer.Stop()
+
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn:      func(httptrace.GotConnInfo) { st.progress() },
+		WroteRequest: func(httptrace.WroteRequestInfo) { st.timer.Stop() },
+	})
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = st.wrap(req.Body)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return st.wrap(body), nil
+			}
+		}
+	}
+	return req, st, func() {
+		st.timer.Stop()
+		cancel(nil)
+	}
+}
+
+// progress restarts the timer.
+func (st *stallTimer) progress() {
+	st.timer.Reset(st.timeout)
+}
+
+// wrap returns body, restarting the timer on every read.
+func (st *stallTimer) wrap(body io.ReadCloser) io.ReadCloser {
+	return &progressReader{ReadCloser: body, progress: st.progress}
+}
+
+// err returns err, noting when the attempt was canceled as stalled.
+func (st *stallTimer) err(err error) error {
+	if !st.stalled.Load() {
+		return err
+	}
+	return fmt.Errorf("%w for transport.io_timeout %s: %w", errConnStalled, st.timeout, err)
+}
+
+// progressReader reports every successful read to progress.
+type progressReader struct {
+	io.ReadCloser
+	progress func()
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.progress()
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionStats is a snapshot of a session's delivery counters.
+type SessionStats struct {
+	TenantID      string    `json:"tenant_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	BatchesSent   int64     `json:"batches_sent"`
+	BatchesFailed int64     `json:"batches_failed"`
+	RecordsSent   int64     `json:"records_sent"`
+	RecordsFailed int64     `json:"records_failed"`
+	BytesSent     int64     `json:"bytes_sent"`
+	Retries       int64     `json:"retries"`
+	Retrying      int64     `json:"retrying"` // requests currently backing off before a retry
+	// ConcurrencyLimit is the adaptive concurrency limit, if enabled.
+	ConcurrencyLimit int        `json:"concurrency_limit,omitempty"`
+	LastSuccessAt    *time.Time `json:"last_success_at,omitempty"`
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+
+	// Circuits is the circuit breaker state by endpoint host, for hosts the
+	// session has sent to.
+	Circuits map[string]string `json:"circuits,omitempty"`
+}
+
+// sessionStats holds the counters behind SessionStats. They are updated
+// atomically from concurrent deliveries.
+type sessionStats struct {
+	createdAt     time.Time
+	batchesSent   atomic.Int64
+	batchesFailed atomic.Int64
+	recordsSent   atomic.Int64
+	recordsFailed atomic.Int64
+	bytesSent     atomic.Int64
+	retries       atomic.Int64
+	retrying      atomic.Int64
+	lastSuccess   atomic.Int64 // unix nanoseconds; zero when none
+	lastFailure   atomic.Pointer[failure]
+	hosts         sync.Map // endpoint host -> struct{}, for breaker lookups
+}
+
+type failure struct {
+	at  time.Time
+	err string
+}
+
+// recordBatch counts the outcome of sending a batch of n records.
+func (st *sessionStats) recordBatch(n int, err error) {
+	now := time.Now()
+	if err == nil {
+		st.batchesSent.Add(1)
+		st.recordsSent.Add(int64(n))
+		st.lastSuccess.Store(now.UnixNano())
+		return
+	}
+
+	failed := n
+	var perr *partialError
+	if errors.As(err, &perr) {
+		failed = len(perr.failed)
+	}
+	st.batchesFailed.Add(1)
+	st.recordsFailed.Add(int64(failed))
+	st.recordsSent.Add(int64(n - failed))
+	st.lastFailure.Store(&failure{at: now, err: err.Error()})
+}
+
+// stats returns a snapshot of the session's counters.
+func (s *HTTPSink) stats(state *sessionState) SessionStats {
+	st := &state.stats
+	out := SessionStats{
+		TenantID:      state.tenantID,
+		CreatedAt:     st.createdAt,
+		BatchesSent:   st.batchesSent.Load(),
+		BatchesFailed: st.batchesFailed.Load(),
+		RecordsSent:   st.recordsSent.Load(),
+		RecordsFailed: st.recordsFailed.Load(),
+		BytesSent:     st.bytesSent.Load(),
+		Retries:       st.retries.Load(),
+		Retrying:      st.retrying.Load(),
+	}
+	if state.adaptive != nil {
+		out.ConcurrencyLimit = state.adaptive.current()
+	}
+	if ns := st.lastSuccess.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		out.LastSuccessAt = &t
+	}
+	if f := st.lastFailure.Load(); f != nil {
+		out.LastErrorAt, out.LastError = &f.at, f.err
+	}
+	st.hosts.Range(func(k, _ any) bool {
+		if b := s.breakers.lookup(k.(string), state.breaker); b != nil {
+			if out.Circuits == nil {
+				out.Circuits = make(map[string]string)
+			}
+			out.Circuits[k.(string)] = b.current().String()
+		}
+		return true
+	})
+	return out
+}
+
+// SessionStats returns the delivery counters of all open sessions, keyed by
+// session ID.
+func (s *HTTPSink) SessionStats() map[string]SessionStats {
+	out := make(map[string]SessionStats)
+	for _, state := range s.activeStates() {
+		out[state.id] = s.stats(state)
+	}
+	return out
+}
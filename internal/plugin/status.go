@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// statusPolicy classifies response status codes. Without configured lists,
+// codes below 400 succeed and 429 and 5xx are retried.
+type statusPolicy struct {
+	success   map[int]bool // nil: any code below 400
+	retriable map[int]bool // nil: 429 and 5xx
+}
+
+func newStatusPolicy(cfg Config) (statusPolicy, error) {
+	success, err := statusSet("success_status_codes", cfg.SuccessStatusCodes)
+	if err != nil {
+		return statusPolicy{}, err
+	}
+	retriable, err := statusSet("retriable_status_codes", cfg.RetriableStatusCodes)
+	if err != nil {
+		return statusPolicy{}, err
+	}
+	for code := range retriable {
+		if success[code] {
+			return statusPolicy{}, fmt.Errorf("status code %d is listed as both success and retriable", code)
+		}
+	}
+	return statusPolicy{success: success, retriable: retriable}, nil
+}
+
+func statusSet(field string, codes []int) (map[int]bool, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("%s: invalid HTTP status code %d", field, code)
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
+func (p statusPolicy) isSuccess(code int) bool {
+	if p.success != nil {
+		return p.success[code]
+	}
+	return code < http.StatusBadRequest
+}
+
+func (p statusPolicy) isRetriable(code int) bool {
+	if p.retriable != nil {
+		return p.retriable[code]
+	}
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
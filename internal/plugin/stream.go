@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+// streamBufferSize is the write buffer between the encoder and the pipe.
+const streamBufferSize = 32 << 10
+
+// streamPayload returns a payload whose body is encoded record by record
+// while the request is being sent, so the assembled body is never held in
+// memory. Each attempt re-encodes the batch from the records.
+func streamPayload(state *sessionState, b batch.Batch) payload {
+	return payload{
+		contentType: "application/json",
+		stream: func(w io.Writer) error {
+			bw := bufio.NewWriterSize(w, streamBufferSize)
+			var err error
+			if state.cfg.BatchFormat == "ndjson" {
+				err = encodeNDJSON(bw, state, b)
+			} else {
+				err = writeEnveloped(bw, state, b)
+			}
+			if err != nil {
+				return &streamError{err: err}
+			}
+			return bw.Flush()
+		},
+	}
+}
+
+// streamError is an encoding failure of a streamed body. It is not retriable,
+// unlike the transport error it surfaces through.
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string { return "failed to stream batch: " + e.err.Error() }
+func (e *streamError) Unwrap() error { return e.err }
+
+// setStreamBody sets a chunked body fed by p.stream through a pipe.
+func (p payload) setStreamBody(req *http.Request) {
+	req.ContentLength = -1
+	req.GetBody = func() (io.ReadCloser, error) { return p.pipe(), nil }
+	req.Body = p.pipe()
+}
+
+// pipe starts encoding into a new pipe and returns its read side. Closing
+// the reader stops the encoder.
+func (p payload) pipe() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.stream(pw))
+	}()
+	return pr
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	return len(b), nil
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+var tracer = otel.Tracer("github.com/planx-lab/planx-plugin-http")
+
+// metadataCarrier adapts incoming gRPC metadata to a propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// extractTraceContext returns ctx carrying the trace context found in the
+// incoming gRPC metadata, if any.
+func extractTraceContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
+
+// startBatchSpan starts the span covering delivery of one batch.
+func startBatchSpan(ctx context.Context, state *sessionState, records int) (context.Context, trace.Span) {
+	endpoint := state.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = state.cfg.EndpointTemplate
+	}
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	return tracer.Start(ctx, "http_sink.send_batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("planx.session_id", state.id),
+			attribute.Int("planx.record_count", records),
+			attribute.String("server.address", host),
+		))
+}
+
+// endBatchSpan records the outcome of a batch delivery and ends the span.
+func endBatchSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTraceContext writes the W3C trace headers for ctx into req.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// recordStatus annotates the active span with the response status code.
+func recordStatus(ctx context.Context, statusCode int) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", statusCode))
+}
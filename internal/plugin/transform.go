@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TransformConfig reshapes every JSON record before it is formatted. Each
+// mapping copies the value at From to To, and Set adds literal values at
+// their paths. Paths are dot-separated; From may be "$" for the whole
+// record, e.g. to wrap it in an envelope. The output holds only mapped and
+// set fields unless Keep is set, in which case it is the record itself with
+// mapped source fields moved, i.e. renamed.
+type TransformConfig struct {
+	Mappings []TransformMapping         `json:"mappings"`
+	Set      map[string]json.RawMessage `json:"set"`
+	Keep     bool                       `json:"keep"`
+}
+
+// TransformMapping copies one value. A record without a value at From fails
+// unless Optional is set, in which case the mapping is skipped.
+type TransformMapping struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Optional bool   `json:"optional"`
+}
+
+// transformer is the parsed form of TransformConfig.
+type transformer struct {
+	mappings []transformMapping
+	set      []transformLiteral
+	keep     bool
+}
+
+type transformMapping struct {
+	from, to []string // from is empty for the whole record
+	optional bool
+}
+
+type transformLiteral struct {
+	to    []string
+	value json.RawMessage
+}
+
+// newTransformer validates the transform, returning nil when none is
+// configured.
+func newTransformer(cfg TransformConfig) (*transformer, error) {
+	if len(cfg.Mappings) == 0 && len(cfg.Set) == 0 {
+		if cfg.Keep {
+			return nil, fmt.Errorf("transform.keep requires mappings or set")
+		}
+		return nil, nil
+	}
+
+	t := &transformer{keep: cfg.Keep}
+	for i, m := range cfg.Mappings {
+		var from []string
+		if m.From != "$" {
+			var err error
+			if from, err = parseTransformPath(m.From); err != nil {
+				return nil, fmt.Errorf("transform.mappings[%d]: invalid from: %w", i, err)
+			}
+		} else if cfg.Keep {
+			return nil, fmt.Errorf("transform.mappings[%d]: from \"$\" cannot be used with keep", i)
+		}
+		to, err := parseTransformPath(m.To)
+		if err != nil {
+			return nil, fmt.Errorf("transform.mappings[%d]: invalid to: %w", i, err)
+		}
+		t.mappings = append(t.mappings, transformMapping{from: from, to: to, optional: m.Optional})
+	}
+	for path, value := range cfg.Set {
+		to, err := parseTransformPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("transform.set: %w", err)
+		}
+		if _, err := decodeJSON(value); err != nil {
+			return nil, fmt.Errorf("transform.set %q: %w", path, err)
+		}
+		t.set = append(t.set, transformLiteral{to: to, value: value})
+	}
+	// Map iteration order is random; set shorter paths first so nested
+	// literals land inside objects set by their parents.
+	slices.SortFunc(t.set, func(a, b transformLiteral) int {
+		return cmp.Or(cmp.Compare(len(a.to), len(b.to)), slices.Compare(a.to, b.to))
+	})
+	return t, nil
+}
+
+func parseTransformPath(path string) ([]string, error) {
+	segs := strings.Split(path, ".")
+	for _, seg := range segs {
+		if seg == "" {
+			return nil, fmt.Errorf("path %q", path)
+		}
+	}
+	return segs, nil
+}
+
+// apply returns the transformed payload. Errors name the path that failed.
+func (t *transformer) apply(raw []byte) ([]byte, error) {
+	rec, err := decodeJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+
+	// Sources are resolved against the record before anything is moved.
+	values := make([]any, len(t.mappings))
+	found := make([]bool, len(t.mappings))
+	for i, m := range t.mappings {
+		values[i], found[i] = lookupPath(rec, m.from)
+		if !found[i] && !m.optional {
+			return nil, fmt.Errorf("no value at %q", strings.Join(m.from, "."))
+		}
+	}
+
+	out := map[string]any{}
+	if t.keep {
+		obj, ok := rec.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("transform.keep requires a JSON object record")
+		}
+		out = obj
+		for i, m := range t.mappings {
+			if found[i] {
+				redactRule{strategy: "drop"}.redact(out, m.from)
+			}
+		}
+	}
+
+	for i, m := range t.mappings {
+		if !found[i] {
+			continue
+		}
+		if err := setPath(out, m.to, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, lit := range t.set {
+		// Decoded per record, as later literals may nest into it.
+		v, _ := decodeJSON(lit.value)
+		if err := setPath(out, lit.to, v); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(out); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func decodeJSON(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// setPath stores v at path below obj, creating intermediate objects.
+func setPath(obj map[string]any, path []string, v any) error {
+	for i, seg := range path[:len(path)-1] {
+		next, ok := obj[seg]
+		if !ok {
+			child := map[string]any{}
+			obj[seg] = child
+			obj = child
+			continue
+		}
+		if obj, ok = next.(map[string]any); !ok {
+			return fmt.Errorf("cannot set %q: %q is not an object", strings.Join(path, "."), strings.Join(path[:i+1], "."))
+		}
+	}
+	obj[path[len(path)-1]] = v
+	return nil
+}
@@ -0,0 +1,464 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/http2"
+)
+
+// TLSConfig configures client certificates and server verification.
+// PEM values may be given inline or as file paths. CACertFile is reloaded
+// when it changes, e.g. as cert-manager rotates the CA. MinVersion and
+// CipherSuites restrict the handshake, e.g. for compliance; Go's defaults
+// apply when they are unset.
+type TLSConfig struct {
+	ClientCertPEM      string   `json:"client_cert_pem"`
+	ClientKeyPEM       string   `json:"client_key_pem"`
+	ClientCertFile     string   `json:"client_cert_file"`
+	ClientKeyFile      string   `json:"client_key_file"`
+	CACertPEM          string   `json:"ca_cert_pem"`          // replaces the system roots when set
+	CACertFile         string   `json:"ca_cert_file"`         // like ca_cert_pem, reloaded on change
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"` // testing only
+	MinVersion         string   `json:"min_version"`          // "1.2" or "1.3"
+	CipherSuites       []string `json:"cipher_suites"`        // IANA names, TLS 1.2 only
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.ClientCertPEM != "" || c.ClientKeyPEM != "" || c.ClientCertFile != "" || c.ClientKeyFile != "" ||
+		c.CACertPEM != "" || c.CACertFile != "" || c.InsecureSkipVerify || c.MinVersion != "" || len(c.CipherSuites) > 0
+}
+
+// tlsVersions maps TLSConfig.MinVersion values to their protocol versions.
+// Older versions are not offered: Go's client already refuses them.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseCipherSuites resolves cipher suite names. Only suites Go considers
+// secure are accepted; TLS 1.3 suites are not configurable in Go.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	var valid []string
+	for _, cs := range tls.CipherSuites() {
+		if slices.Contains(cs.SupportedVersions, tls.VersionTLS12) {
+			byName[cs.Name] = cs.ID
+			valid = append(valid, cs.Name)
+		}
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("tls: unsupported cipher suite %q: must be one of %s", name, strings.Join(valid, ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// TransportConfig tunes connection pooling. When the block is omitted the
+// Go defaults apply: 100 idle connections in total, 2 idle connections per
+// host, no cap on connections per host, a 90s idle timeout and a 1s
+// expect-continue timeout. Zero fields keep those defaults. Once
+// MaxConnsPerHost connections to a host are busy, requests wait for one to
+// become free, for at most ConnWaitTimeout when set. TrackConnReuse counts
+// whether each request reused a pooled connection or opened a new one, to
+// diagnose connection churn. IOTimeout fails attempts whose connection
+// makes no progress for that long while the request is written or the
+// response body read, such as connections a load balancer dropped
+// silently; they are retried like connection resets.
+type TransportConfig struct {
+	MaxIdleConns          int    `json:"max_idle_conns"`
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int    `json:"max_conns_per_host"`
+	IdleConnTimeout       string `json:"idle_conn_timeout"`       // e.g., "90s"
+	ExpectContinueTimeout string `json:"expect_continue_timeout"` // e.g., "1s"
+	ConnWaitTimeout       string `json:"conn_wait_timeout"`       // e.g., "5s"
+	TrackConnReuse        bool   `json:"track_conn_reuse"`
+	IOTimeout             string `json:"io_timeout"` // e.g., "15s"
+}
+
+func (c TransportConfig) enabled() bool {
+	return c != TransportConfig{}
+}
+
+// HTTP2Config controls HTTP/2 use. Enabled negotiates HTTP/2 over TLS via
+// ALPN; PriorKnowledge additionally speaks cleartext HTTP/2 (h2c) to http://
+// endpoints without an upgrade. Connection pool settings do not apply to h2c.
+type HTTP2Config struct {
+	Enabled        bool `json:"enabled"`
+	PriorKnowledge bool `json:"prior_knowledge"`
+}
+
+// validate checks the HTTP/2 settings against the configured endpoints.
+func (c HTTP2Config) validate(cfg Config) error {
+	if !c.PriorKnowledge {
+		return nil
+	}
+	if !c.Enabled {
+		return fmt.Errorf("http2.prior_knowledge requires http2.enabled")
+	}
+	if cfg.Proxy != "" {
+		return fmt.Errorf("http2.prior_knowledge cannot be used with a proxy")
+	}
+	endpoints := []string{cfg.Endpoint, cfg.EndpointTemplate}
+	for _, e := range cfg.Endpoints {
+		endpoints = append(endpoints, e.URL)
+	}
+	for _, endpoint := range endpoints {
+		if endpoint != "" && !strings.HasPrefix(strings.ToLower(endpoint), "http://") {
+			return fmt.Errorf("http2.prior_knowledge requires an http:// endpoint, got %q", endpoint)
+		}
+	}
+	return nil
+}
+
+// RedirectConfig controls how redirects are followed.
+type RedirectConfig struct {
+	Policy       string `json:"policy"`        // follow (default), never, same_host_only
+	MaxRedirects int    `json:"max_redirects"` // for follow and same_host_only; default 10
+}
+
+const defaultMaxRedirects = 10
+
+// checkRedirect returns the client's redirect policy. Credentials are never
+// forwarded to another host. Under "never" the 3xx response itself is
+// returned and classified like any other status.
+func (c RedirectConfig) checkRedirect() (func(*http.Request, []*http.Request) error, error) {
+	if c.MaxRedirects < 0 {
+		return nil, fmt.Errorf("redirect.max_redirects must be >= 0")
+	}
+	limit := c.MaxRedirects
+	if limit == 0 {
+		limit = defaultMaxRedirects
+	}
+
+	switch c.Policy {
+	case "", "follow", "same_host_only":
+	case "never":
+		return func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }, nil
+	default:
+		return nil, fmt.Errorf("unsupported redirect.policy %q: must be one of follow, never, same_host_only", c.Policy)
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= limit {
+			return fmt.Errorf("stopped after %d redirects", limit)
+		}
+		if req.URL.Host == via[0].URL.Host {
+			return nil
+		}
+		if c.Policy == "same_host_only" {
+			return fmt.Errorf("redirect to another host %s refused", req.URL.Host)
+		}
+		for _, k := range sensitiveHeaders {
+			req.Header.Del(k)
+		}
+		return nil
+	}, nil
+}
+
+// transportKey identifies the settings that shape a session's HTTP client.
+// Sessions with equal keys can share a client.
+func transportKey(cfg Config) string {
+	b, _ := json.Marshal(struct {
+		Timeout     string
+		Phases      [3]string
+		TLS         TLSConfig
+		Transport   TransportConfig
+		Proxy       string
+		ProxyBypass []string
+		HTTP2       HTTP2Config
+		Redirect    RedirectConfig
+		Dialer      DialerConfig
+		UnixSocket  string
+	}{cfg.Timeout, [3]string{cfg.ConnectTimeout, cfg.TLSHandshakeTimeout, cfg.ResponseHeaderTimeout}, cfg.TLS, cfg.Transport, cfg.Proxy, cfg.ProxyBypass, cfg.HTTP2, cfg.Redirect, cfg.Dialer, cfg.unixSocket})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// newClient builds an HTTP client from the session config.
+func newClient(cfg Config) (*http.Client, error) {
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", cfg.Timeout, err)
+		}
+		timeout = d
+	}
+	checkRedirect, err := cfg.Redirect.checkRedirect()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout, CheckRedirect: checkRedirect}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+// newTransport builds the session transport. Its connections are counted
+// per host for the connection pool metrics.
+func newTransport(cfg Config) (http.RoundTripper, error) {
+	dialer, err := newDialer(cfg.Dialer)
+	if err != nil {
+		return nil, err
+	}
+	lifetime, err := parseConnMaxLifetime(cfg.Dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialer.Timeout, err = parsePhaseTimeout("connect_timeout", cfg.ConnectTimeout, defaultDialTimeout); err != nil {
+		return nil, err
+	}
+	tracker, err := newConnTracker(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := dialer.DialContext
+	if socket := cfg.unixSocket; socket != "" {
+		// Every request goes to the socket, whatever its URL host.
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	if isSOCKSProxy(cfg.Proxy) {
+		if dial, err = newSOCKSDial(cfg.Proxy, cfg.ProxyBypass, dial); err != nil {
+			return nil, err
+		}
+	}
+	dial = tracker.dial(dial)
+
+	if cfg.HTTP2.PriorKnowledge {
+		if cfg.TLSHandshakeTimeout != "" || cfg.ResponseHeaderTimeout != "" {
+			return nil, fmt.Errorf("tls_handshake_timeout and response_header_timeout cannot be used with http2.prior_knowledge")
+		}
+		tracker.idleCloser = newH2CTransport(dial)
+	} else if tracker.idleCloser, err = newHTTPTransport(cfg, dial); err != nil {
+		return nil, err
+	}
+
+	if lifetime > 0 {
+		return newRecyclingTransport(tracker, lifetime), nil
+	}
+	return tracker, nil
+}
+
+// newHTTPTransport builds an HTTP/1.1 transport, negotiating HTTP/2 over TLS
+// when enabled.
+func newHTTPTransport(cfg Config, dial dialFunc) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dial
+	if cfg.HTTP2.Enabled {
+		t.ForceAttemptHTTP2 = true
+	}
+
+	switch {
+	case isSOCKSProxy(cfg.Proxy):
+		// SOCKS proxies are reached through the dial func.
+		t.Proxy = nil
+	case cfg.Proxy != "":
+		proxy, err := newProxyFunc(cfg.Proxy, cfg.ProxyBypass)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = proxy
+	}
+
+	if cfg.TLS.enabled() {
+		tlsCfg, reloader, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsCfg
+		if reloader != nil {
+			t.DialTLSContext = reloader.dialTLS(t, dial)
+		}
+	}
+
+	var err error
+	if t.TLSHandshakeTimeout, err = parsePhaseTimeout("tls_handshake_timeout", cfg.TLSHandshakeTimeout, t.TLSHandshakeTimeout); err != nil {
+		return nil, err
+	}
+	if t.ResponseHeaderTimeout, err = parsePhaseTimeout("response_header_timeout", cfg.ResponseHeaderTimeout, 0); err != nil {
+		return nil, err
+	}
+
+	tc := cfg.Transport
+	if tc.MaxIdleConns < 0 || tc.MaxIdleConnsPerHost < 0 || tc.MaxConnsPerHost < 0 {
+		return nil, fmt.Errorf("transport connection limits must be >= 0")
+	}
+	if tc.MaxIdleConns > 0 {
+		t.MaxIdleConns = tc.MaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+	if tc.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = tc.MaxConnsPerHost
+	}
+	if tc.IdleConnTimeout != "" {
+		d, err := time.ParseDuration(tc.IdleConnTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transport.idle_conn_timeout: %w", err)
+		}
+		t.IdleConnTimeout = d
+	}
+	if tc.ExpectContinueTimeout != "" {
+		d, err := time.ParseDuration(tc.ExpectContinueTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid transport.expect_continue_timeout %q", tc.ExpectContinueTimeout)
+		}
+		t.ExpectContinueTimeout = d
+	}
+
+	return t, nil
+}
+
+// parsePhaseTimeout parses the timeout setting name, returning def when it
+// is unset.
+func parsePhaseTimeout(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// newH2CTransport speaks HTTP/2 over cleartext TCP with prior knowledge.
+func newH2CTransport(dial dialFunc) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+}
+
+// newTLSConfig builds the TLS settings of cfg. The returned reloader is nil
+// unless CACertFile is verified against.
+func newTLSConfig(cfg TLSConfig) (*tls.Config, *caReloader, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.InsecureSkipVerify {
+		logger.Warn().Msg("TLS certificate verification is disabled (insecure_skip_verify)")
+	}
+
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, nil, fmt.Errorf("tls: unsupported min_version %q: must be 1.2 or 1.3", cfg.MinVersion)
+		}
+		tlsCfg.MinVersion = v
+	}
+	if len(cfg.CipherSuites) > 0 {
+		if tlsCfg.MinVersion == tls.VersionTLS13 {
+			return nil, nil, fmt.Errorf("tls: cipher_suites cannot be set with min_version 1.3, whose suites are fixed")
+		}
+		suites, err := parseCipherSuites(cfg.CipherSuites)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.CipherSuites = suites
+	}
+
+	certPEM, keyPEM := []byte(cfg.ClientCertPEM), []byte(cfg.ClientKeyPEM)
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if len(certPEM) > 0 || len(keyPEM) > 0 {
+			return nil, nil, fmt.Errorf("tls: set either inline client PEM or client files, not both")
+		}
+		var err error
+		if certPEM, err = os.ReadFile(cfg.ClientCertFile); err != nil {
+			return nil, nil, fmt.Errorf("tls: failed to read client certificate: %w", err)
+		}
+		if keyPEM, err = os.ReadFile(cfg.ClientKeyFile); err != nil {
+			return nil, nil, fmt.Errorf("tls: failed to read client key: %w", err)
+		}
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: invalid client certificate or key: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CACertPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CACertPEM)) {
+			return nil, nil, fmt.Errorf("tls: ca_cert_pem contains no valid certificates")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CACertFile != "" {
+		if cfg.CACertPEM != "" {
+			return nil, nil, fmt.Errorf("tls: set either ca_cert_pem or ca_cert_file, not both")
+		}
+		r, err := newCAReloader(cfg.CACertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !cfg.InsecureSkipVerify {
+			r.apply(tlsCfg)
+			return tlsCfg, r, nil
+		}
+	}
+
+	return tlsCfg, nil, nil
+}
+
+// newProxyFunc routes requests through proxyURL except for hosts matched by
+// bypass, which uses NO_PROXY syntax (hosts, domain suffixes, CIDRs, "*").
+func newProxyFunc(proxyURL string, bypass []string) (func(*http.Request) (*url.URL, error), error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", redactURL(proxyURL))
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q: must be http, https, socks5 or socks5h", u.Scheme)
+	}
+
+	fn := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    strings.Join(bypass, ","),
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}, nil
+}
+
+// redactURL masks any password embedded in rawURL for logging.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparseable>"
+	}
+	return u.Redacted()
+}
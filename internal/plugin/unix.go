@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixDialTimeout = 2 * time.Second
+
+// resolveUnixEndpoint rewrites a unix:// endpoint such as
+// unix:///var/run/sink.sock/ingest into the socket to dial and an HTTP URL
+// whose path is the remainder, http://localhost/ingest. The socket is the
+// longest-matching leading part of the path that is a Unix socket, and must
+// accept connections.
+func resolveUnixEndpoint(endpoint string) (socket, httpURL string, err error) {
+	rest, query, _ := strings.Cut(strings.TrimPrefix(endpoint, "unix://"), "?")
+	if !strings.HasPrefix(rest, "/") {
+		return "", "", fmt.Errorf("invalid endpoint %q: unix endpoints take an absolute socket path, e.g. unix:///var/run/sink.sock/ingest", endpoint)
+	}
+
+	segs := strings.Split(rest, "/")
+	for i := 2; i <= len(segs); i++ {
+		candidate := strings.Join(segs[:i], "/")
+		fi, err := os.Stat(candidate)
+		if err != nil {
+			break
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			socket, rest = candidate, "/"+strings.Join(segs[i:], "/")
+			break
+		}
+		if !fi.IsDir() {
+			break
+		}
+	}
+	if socket == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q: no Unix socket found along the path", endpoint)
+	}
+
+	conn, err := net.DialTimeout("unix", socket, unixDialTimeout)
+	if err != nil {
+		return "", "", fmt.Errorf("unix socket %s is not accepting connections: %w", socket, err)
+	}
+	conn.Close()
+
+	httpURL = "http://localhost" + rest
+	if query != "" {
+		httpURL += "?" + query
+	}
+	return socket, httpURL, nil
+}
@@ -0,0 +1,26 @@
+package plugin
+
+import "net/http"
+
+// defaultUserAgent identifies the sink to endpoints unless a session
+// configures its own. SetVersion adds the build version.
+var defaultUserAgent = "planx-plugin-http"
+
+// SetVersion sets the plugin version reported in the default User-Agent.
+// It must be called before any session is created.
+func SetVersion(version string) {
+	if version != "" {
+		defaultUserAgent = "planx-plugin-http/" + version
+	}
+}
+
+// setUserAgent applies the session's User-Agent to h. The user_agent setting
+// overrides a User-Agent in headers, which overrides the default.
+func setUserAgent(state *sessionState, h http.Header) {
+	switch {
+	case state.cfg.UserAgent != "":
+		h.Set("User-Agent", state.cfg.UserAgent)
+	case h.Get("User-Agent") == "":
+		h.Set("User-Agent", defaultUserAgent)
+	}
+}
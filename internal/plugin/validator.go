@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// ResponseValidatorConfig is a predicate over responses that must hold for
+// a request to succeed: the status must be one of Status, each of Headers
+// present with the given value, or with any value when it is empty, and
+// every Body assertion hold. They are checked in that order, stopping at
+// the first failure, after the status policy and retry_response_match; see
+// responseEvaluator for the full order. A failed validation is retried
+// under the retry policy only when Retriable is set.
+type ResponseValidatorConfig struct {
+	Status    []int                 `json:"status"`
+	Headers   map[string]string     `json:"headers"` // e.g. {"X-Processed": "true"}
+	Body      []BodyAssertionConfig `json:"body"`
+	Retriable bool                  `json:"retriable"`
+}
+
+// BodyAssertionConfig requires the value at Path in a JSON or XML body to
+// be one of Equals, or to match the regular expression Pattern, or, with
+// neither, to be present and not null. Every element of an array value
+// must satisfy it.
+type BodyAssertionConfig struct {
+	Path    string   `json:"path"`
+	Equals  []string `json:"equals"`
+	Pattern string   `json:"pattern"`
+}
+
+// responseValidator is the parsed form of ResponseValidatorConfig.
+type responseValidator struct {
+	status    map[int]bool
+	headers   [][2]string // name, value; in name order
+	body      []bodyAssertion
+	retriable bool
+}
+
+type bodyAssertion struct {
+	raw     string
+	path    []string
+	equals  []string
+	pattern *regexp.Regexp
+}
+
+// newResponseValidator validates cfg, returning nil when it checks nothing.
+func newResponseValidator(cfg ResponseValidatorConfig) (*responseValidator, error) {
+	if len(cfg.Status) == 0 && len(cfg.Headers) == 0 && len(cfg.Body) == 0 {
+		if cfg.Retriable {
+			return nil, fmt.Errorf("response_validator.retriable requires status, headers or body checks")
+		}
+		return nil, nil
+	}
+
+	status, err := statusSet("response_validator.status", cfg.Status)
+	if err != nil {
+		return nil, err
+	}
+	v := &responseValidator{status: status, retriable: cfg.Retriable}
+	for name, value := range cfg.Headers {
+		v.headers = append(v.headers, [2]string{http.CanonicalHeaderKey(name), value})
+	}
+	sort.Slice(v.headers, func(i, j int) bool { return v.headers[i][0] < v.headers[j][0] })
+
+	for i, b := range cfg.Body {
+		path, err := parseExtractPath(b.Path)
+		if err != nil || path == nil {
+			return nil, fmt.Errorf("invalid response_validator.body[%d].path %q", i, b.Path)
+		}
+		a := bodyAssertion{raw: b.Path, path: path, equals: b.Equals}
+		if b.Pattern != "" {
+			if len(b.Equals) > 0 {
+				return nil, fmt.Errorf("response_validator.body[%d]: set either equals or pattern, not both", i)
+			}
+			if a.pattern, err = regexp.Compile(b.Pattern); err != nil {
+				return nil, fmt.Errorf("invalid response_validator.body[%d].pattern: %w", i, err)
+			}
+		}
+		v.body = append(v.body, a)
+	}
+	return v, nil
+}
+
+// check returns a *statusError naming the first check resp fails. The
+// inspected part of the body is put back in front of resp.Body so it can
+// still be captured.
+func (v *responseValidator) check(state *sessionState, resp *http.Response) error {
+	reason := v.failure(state, resp)
+	if reason == "" {
+		return nil
+	}
+	return &statusError{StatusCode: resp.StatusCode, Body: "response_validator: " + reason, Retriable: v.retriable}
+}
+
+// failure returns why resp fails validation, or "" when it passes.
+func (v *responseValidator) failure(state *sessionState, resp *http.Response) string {
+	if v.status != nil && !v.status[resp.StatusCode] {
+		return "status " + strconv.Itoa(resp.StatusCode) + " not accepted"
+	}
+	for _, h := range v.headers {
+		values, ok := resp.Header[h[0]]
+		switch {
+		case !ok:
+			return fmt.Sprintf("header %s missing", h[0])
+		case h[1] != "" && !slices.Contains(values, h[1]):
+			return fmt.Sprintf("header %s is %q, want %q", h[0], resp.Header.Get(h[0]), h[1])
+		}
+	}
+	if len(v.body) == 0 {
+		return ""
+	}
+
+	body, truncated := peekBody(resp, maxExtractBytes)
+	if truncated {
+		logger.Warn().
+			Str("session_id", state.id).
+			Int("limit", maxExtractBytes).
+			Msg("Response too large to check response_validator body assertions")
+		return fmt.Sprintf("body larger than %d bytes", maxExtractBytes)
+	}
+	for _, a := range v.body {
+		value, err := extractValue(resp.Header.Get("Content-Type"), body, a.path)
+		if err != nil {
+			return fmt.Sprintf("body %s: %v", a.raw, err)
+		}
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, val := range values {
+			if reason := a.failure(val); reason != "" {
+				return fmt.Sprintf("body %s %s", a.raw, reason)
+			}
+		}
+	}
+	return ""
+}
+
+// failure returns why value fails the assertion, or "" when it holds.
+func (a bodyAssertion) failure(value any) string {
+	if value == nil {
+		return "missing"
+	}
+	s := fmt.Sprint(value)
+	switch {
+	case a.pattern != nil && !a.pattern.MatchString(s):
+		return fmt.Sprintf("is %q, want a match of %s", s, a.pattern)
+	case len(a.equals) > 0 && !slices.Contains(a.equals, s):
+		return fmt.Sprintf("is %q, want one of %q", s, a.equals)
+	}
+	return ""
+}
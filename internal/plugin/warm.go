@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/planx-lab/planx-common/logger"
+)
+
+// warmTimeout bounds the warm-up of a session's connections.
+const warmTimeout = 5 * time.Second
+
+// validateWarmConnections checks that the connections cfg.WarmConnections
+// opens can stay in the transport's idle pool.
+func validateWarmConnections(cfg Config) error {
+	n := cfg.WarmConnections
+	if n < 0 {
+		return fmt.Errorf("warm_connections must be >= 0")
+	}
+	if n == 0 {
+		return nil
+	}
+	if cfg.EndpointTemplate != "" {
+		return fmt.Errorf("warm_connections cannot be used with endpoint_template")
+	}
+	tc := cfg.Transport
+	idle := tc.MaxIdleConnsPerHost
+	if idle == 0 {
+		idle = http.DefaultMaxIdleConnsPerHost
+	}
+	if n > idle {
+		return fmt.Errorf("warm_connections (%d) exceeds transport.max_idle_conns_per_host (%d); the extra connections would be closed", n, idle)
+	}
+	if tc.MaxConnsPerHost > 0 && n > tc.MaxConnsPerHost {
+		return fmt.Errorf("warm_connections (%d) exceeds transport.max_conns_per_host (%d)", n, tc.MaxConnsPerHost)
+	}
+	return nil
+}
+
+// warmConnections opens cfg.WarmConnections connections to each endpoint of
+// the session with concurrent HEAD requests, leaving them idle in the pool
+// for the first batches. Any response counts, since ingest endpoints
+// commonly reject HEAD; failures are only logged.
+func (s *HTTPSink) warmConnections(ctx context.Context, state *sessionState) {
+	endpoints := []string{state.cfg.Endpoint}
+	if len(state.cfg.Endpoints) > 0 {
+		endpoints = endpoints[:0]
+		for _, e := range state.cfg.Endpoints {
+			endpoints = append(endpoints, e.URL)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, endpoint := range endpoints {
+		for range state.cfg.WarmConnections {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := warmRequest(ctx, state, endpoint); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	total := len(endpoints) * state.cfg.WarmConnections
+	if len(errs) > 0 {
+		logger.Warn().
+			Err(errors.Join(errs...)).
+			Str("session_id", state.id).
+			Int("failed", len(errs)).
+			Int("requested", total).
+			Msg("Failed to warm some connections; they will be opened on demand")
+		return
+	}
+	logger.Info().
+		Str("session_id", state.id).
+		Int("connections", total).
+		Dur("elapsed", time.Since(start)).
+		Msg("Warmed connections")
+}
+
+// warmRequest sends one warm-up HEAD request to endpoint, returning its
+// connection to the pool.
+func warmRequest(ctx context.Context, state *sessionState, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", redactURL(endpoint), err)
+	}
+	for k, v := range state.headers.static {
+		req.Header[k] = v
+	}
+	setUserAgent(state, req.Header)
+	if state.auth != nil {
+		if err := state.auth.apply(ctx, req); err != nil {
+			return err
+		}
+	}
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("endpoint %s unreachable: %w", redactURL(endpoint), redactAPIKey(state.auth, err))
+	}
+	drainBody(resp.Body)
+	return nil
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/planx-lab/planx-sdk-go/batch"
+)
+
+const (
+	defaultXMLRootElement   = "records"
+	defaultXMLRecordElement = "record"
+)
+
+// encodeXML wraps the records of b in the root element, one record element
+// per record. JSON object fields become child elements in payload order and
+// array values repeat the field's element.
+func encodeXML(cfg Config, b batch.Batch) ([]byte, error) {
+	root := xml.StartElement{Name: xml.Name{Local: cmp.Or(cfg.XMLRootElement, defaultXMLRootElement)}}
+	record := xml.StartElement{Name: xml.Name{Local: cmp.Or(cfg.XMLRecordElement, defaultXMLRecordElement)}}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := enc.EncodeToken(root); err != nil {
+		return nil, err
+	}
+	for i, r := range b.Records {
+		dec := json.NewDecoder(bytes.NewReader(r.Payload))
+		dec.UseNumber()
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, fmt.Errorf("record %d: payload is not a JSON object", i)
+		}
+		if err := enc.EncodeToken(record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		if err := writeXMLObject(enc, dec); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		if err := enc.EncodeToken(record.End()); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write xml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeXMLObject writes the remaining fields of the JSON object whose opening
+// brace has already been read from dec.
+func writeXMLObject(enc *xml.Encoder, dec *json.Decoder) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := tok.(string)
+		if !isXMLName(name) {
+			return fmt.Errorf("field %q is not a valid XML element name", name)
+		}
+		if err := writeXMLValue(enc, dec, name); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token() // closing '}'
+	return err
+}
+
+// writeXMLValue writes the next JSON value of dec as element name.
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, name string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			for dec.More() {
+				if err := writeXMLValue(enc, dec, name); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token() // closing ']'
+			return err
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := writeXMLObject(enc, dec); err != nil {
+			return err
+		}
+	case nil:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+	default:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether s can be used as an unqualified XML element name.
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// decodeXML converts an XML document into the generic form of a decoded JSON
+// document, so response paths apply to both. The root element's content is
+// the top-level value: attributes become "@name" fields, child elements
+// become fields (repeated elements become arrays) and text-only elements
+// become strings. Text mixed with child elements is kept as "#text".
+func decodeXML(body []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return decodeXMLElement(dec, start)
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	fields := make(map[string]any)
+	for _, attr := range start.Attr {
+		fields["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			name := tok.Name.Local
+			switch prev := fields[name].(type) {
+			case nil:
+				fields[name] = child
+			case []any:
+				fields[name] = append(prev, child)
+			default:
+				fields[name] = []any{prev, child}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(fields) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				fields["#text"] = s
+			}
+			return fields, nil
+		}
+	}
+}